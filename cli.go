@@ -0,0 +1,281 @@
+package main
+
+import (
+	"SharepointBot/db"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var ErrUnknownCommand = errors.New("unknown command")
+
+func (server *httpImpl) RunCommand(args []string) error {
+	switch args[0] {
+	case "outbox":
+		return server.outboxCommand(args[1:])
+	case "routes":
+		return server.routesCommand()
+	case "revisions":
+		return server.revisionsCommand(args[1:])
+	case "versions":
+		return server.versionsCommand(args[1:])
+	case "migrate":
+		return server.migrateCommand(args[1:])
+	case "search":
+		return server.searchCommand(args[1:])
+	case "prune":
+		return server.pruneCommand()
+	case "export":
+		return server.exportCommand(args[1:])
+	case "import":
+		return server.importCommand(args[1:])
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownCommand, args[0])
+}
+
+func (server *httpImpl) outboxCommand(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		// brez podanega stanja izpišemo vse, kar potrebuje ročni pregled
+		statuses := []string{db.OutboxStatusDead, db.OutboxStatusUncertain}
+		if len(args) > 1 {
+			statuses = args[1:2]
+		}
+		entries := make([]db.OutboxEntry, 0)
+		for _, status := range statuses {
+			byStatus, err := server.db.GetOutboxEntriesByStatus(status)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, byStatus...)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNOTIFICATION\tTARGET\tACTION\tSTATUS\tATTEMPTS\tUPDATED\tLAST ERROR")
+		for _, entry := range entries {
+			updated := time.Unix(int64(entry.UpdatedOn), 0).Format("02. 01. 2006 15.04")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", entry.ID, entry.NotificationID, entry.TargetID, entry.Action, entry.Status, entry.Attempts, updated, entry.LastError)
+		}
+		return w.Flush()
+	}
+
+	if args[0] == "replay" {
+		if len(args) < 2 {
+			return errors.New("usage: outbox replay <id|all>")
+		}
+		if args[1] != "all" {
+			return server.ReplayOutboxEntry(args[1])
+		}
+		entries, err := server.db.GetOutboxEntriesByStatus(db.OutboxStatusDead)
+		if err != nil {
+			return err
+		}
+		uncertain, err := server.db.GetOutboxEntriesByStatus(db.OutboxStatusUncertain)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, uncertain...)
+		for _, entry := range entries {
+			err = server.ReplayOutboxEntry(entry.ID)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Replaying %d dead or uncertain deliveries.\n", len(entries))
+		return nil
+	}
+
+	return fmt.Errorf("%w: outbox %s", ErrUnknownCommand, args[0])
+}
+
+// routesCommand izpiše, kam bi pravila usmerjanja poslala shranjena obvestila, ne da bi kaj poslal.
+func (server *httpImpl) routesCommand() error {
+	notifications, err := server.db.GetSharepointNotifications()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tTARGETS")
+	for _, notification := range notifications {
+		ids := make([]string, 0)
+		for _, target := range server.RouteNotification(notification) {
+			ids = append(ids, target.ID)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", notification.ID, notification.Name, strings.Join(ids, ", "))
+	}
+	return w.Flush()
+}
+
+func (server *httpImpl) revisionsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: revisions <notification> [version]")
+	}
+
+	if len(args) > 1 {
+		revision, err := server.db.GetNotificationRevision(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		modified := time.Unix(int64(revision.ModifiedOn), 0).Format("02. 01. 2006 15.04")
+		fmt.Printf("%s (%s)\nSpremenil: %s, %s\nETag: %s\n\n%s\n", revision.Title, revision.Version, revision.ModifiedBy, modified, revision.ETag, revision.Markdown)
+		return nil
+	}
+
+	revisions, err := server.db.GetNotificationRevisions(args[0])
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tMODIFIED\tMODIFIED BY\tTITLE")
+	for _, revision := range revisions {
+		modified := time.Unix(int64(revision.ModifiedOn), 0).Format("02. 01. 2006 15.04")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", revision.Version, modified, revision.ModifiedBy, revision.Title)
+	}
+	return w.Flush()
+}
+
+// versionsCommand uvozi zgodovino različic iz SharePointa za eno ali vsa obvestila.
+func (server *httpImpl) versionsCommand(args []string) error {
+	ctx := context.Background()
+	accessToken, err := server.RefreshAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	client := GraphClient(accessToken)
+
+	if len(args) == 0 {
+		return server.ImportAllSharepointVersions(ctx, client)
+	}
+
+	notification, err := server.db.GetSharepointNotification(args[0])
+	if err != nil {
+		return err
+	}
+	list, itemID := SplitNotificationID(notification)
+	imported, err := server.ImportSharepointVersions(ctx, client, list, itemID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d versions.\n", imported)
+	return nil
+}
+
+func (server *httpImpl) migrateCommand(args []string) error {
+	if len(args) != 0 && args[0] == "up" {
+		return server.db.Migrate()
+	}
+	if len(args) != 0 && args[0] != "status" {
+		return fmt.Errorf("%w: migrate %s", ErrUnknownCommand, args[0])
+	}
+
+	status, err := server.db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		applied := "pending"
+		if s.Applied {
+			applied = time.Unix(int64(s.AppliedOn), 0).Format("02. 01. 2006 15.04")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return w.Flush()
+}
+
+// searchCommand poišče obvestila v arhivu po naslovu in besedilu.
+func (server *httpImpl) searchCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: search <query>")
+	}
+	results, err := server.db.SearchSharepointNotifications(strings.Join(args, " "), 20)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED\tID\tTITLE\tSNIPPET")
+	for _, result := range results {
+		created := time.Unix(int64(result.CreatedOn), 0).Format("02. 01. 2006")
+		snippet := strings.Join(strings.Fields(result.Snippet), " ")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", created, result.ID, result.Name, snippet)
+	}
+	return w.Flush()
+}
+
+func (server *httpImpl) pruneCommand() error {
+	notifications, revisions, err := server.Prune()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pruned %d notifications and %d revisions.\n", notifications, revisions)
+	return nil
+}
+
+// commandDatabase vrne bazo, podano z gonilnikom in DSN, ali bazo iz konfiguracije, če ni podana.
+func (server *httpImpl) commandDatabase(args []string) (db.SQL, error) {
+	if len(args) == 0 {
+		return server.db, nil
+	}
+	if len(args) != 2 {
+		return nil, errors.New("database must be given as <driver> <dsn>")
+	}
+	database, err := db.NewSQL(args[0], args[1], server.logger)
+	if err != nil {
+		return nil, err
+	}
+	return database, database.Migrate()
+}
+
+// exportCommand izvozi obvestila, dostave in različice. Pri CSV je pot mapa s tremi datotekami.
+func (server *httpImpl) exportCommand(args []string) error {
+	if len(args) < 2 || (args[0] != ExportFormatJSONL && args[0] != ExportFormatCSV) {
+		return errors.New("usage: export <jsonl|csv> <path> [<driver> <dsn>]")
+	}
+	database, err := server.commandDatabase(args[2:])
+	if err != nil {
+		return err
+	}
+	records, err := ExportRecords(database)
+	if err != nil {
+		return err
+	}
+	if args[0] == ExportFormatCSV {
+		err = WriteCSV(args[1], records)
+	} else {
+		err = WriteJSONL(args[1], records)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d notifications.\n", len(records))
+	return nil
+}
+
+// importCommand uvozi izvoz ali arhiv hranjenja, po želji v drugo bazo (npr. iz sqlite3 v postgres).
+func (server *httpImpl) importCommand(args []string) error {
+	if len(args) < 2 || (args[0] != ExportFormatJSONL && args[0] != ExportFormatCSV) {
+		return errors.New("usage: import <jsonl|csv> <path> [<driver> <dsn>]")
+	}
+	database, err := server.commandDatabase(args[2:])
+	if err != nil {
+		return err
+	}
+	var records []ArchiveRecord
+	if args[0] == ExportFormatCSV {
+		records, err = ReadCSV(args[1])
+	} else {
+		records, err = ReadJSONL(args[1])
+	}
+	if err != nil {
+		return err
+	}
+	imported, err := ImportRecords(database, records)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d new notifications from %d records.\n", imported, len(records))
+	return nil
+}
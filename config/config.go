@@ -2,9 +2,114 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 )
 
+// DefaultList je seznam obvestil na intranetu (Lists/ObvAkt).
+const DefaultList = "54521912-06dd-4ccc-8edb-8173c9629fd8"
+
+const (
+	EditPolicyEdit   = "edit"
+	EditPolicyRepost = "repost"
+)
+
+const (
+	ModeMessage = "message"
+	ModeDigest  = "digest"
+)
+
+const (
+	OnDeletedRemove = "remove"
+	OnDeletedRepost = "repost"
+)
+
+type Target struct {
+	ID      string `json:"id"`
+	Webhook string `json:"webhook"`
+	// Backfill ob prvem zagonu novemu cilju objavi vsa še veljavna obvestila.
+	Backfill bool `json:"backfill"`
+	// OnDeleted določa, kaj storimo, ko moderator izbriše sporočilo (remove ali repost).
+	OnDeleted string    `json:"on_deleted"`
+	Mentions  []Mention `json:"mentions"`
+	// Window omeji objavljanje novih obvestil na časovno okno, urejanja se izvedejo takoj.
+	Window *Window `json:"window"`
+	// Mode je message (sporočilo za vsako obvestilo) ali digest (en pregled na dan ob DigestAt).
+	Mode     string `json:"mode"`
+	DigestAt string `json:"digest_at"`
+	// EditPolicy je edit (obstoječe sporočilo se posodobi) ali repost (ob bistveni spremembi
+	// naslova ali besedila se objavi novo sporočilo s povzetkom sprememb).
+	EditPolicy string `json:"edit_policy"`
+}
+
+func (target Target) IsDigest() bool {
+	return target.Mode == ModeDigest
+}
+
+// Window je dnevno časovno okno v obliki "HH:MM" v časovnem pasu konfiguracije.
+// Če je End pred Start, okno poteka čez polnoč, če sta enaka, je okno vedno odprto.
+type Window struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Mention ob ujemanju naslova in besedila (regularna izraza) pingne podane vloge in uporabnike.
+type Mention struct {
+	Title    string   `json:"title"`
+	Body     string   `json:"body"`
+	Roles    []string `json:"roles"`
+	Users    []string `json:"users"`
+	Everyone bool     `json:"everyone"`
+}
+
+// Route določa, kateri cilji prejmejo obvestila, ki ustrezajo vsem podanim pogojem.
+// Prazni pogoji se ne preverjajo, besedilni pogoji so regularni izrazi.
+type Route struct {
+	Name        string            `json:"name"`
+	Targets     []string          `json:"targets"`
+	List        string            `json:"list"`
+	Title       string            `json:"title"`
+	Body        string            `json:"body"`
+	Author      string            `json:"author"`
+	ContentType string            `json:"content_type"`
+	Attachments *bool             `json:"attachments"`
+	Fields      map[string]string `json:"fields"`
+}
+
+// Retention določa, koliko časa hranimo obvestila in koliko različic. Ničelne vrednosti pomenijo brez omejitve.
+type Retention struct {
+	// ExpiredDays je število dni po poteku obvestila, po katerem ga izbrišemo.
+	ExpiredDays int `json:"expired_days"`
+	// Revisions je število najnovejših različic, ki jih hranimo za vsako obvestilo.
+	Revisions int `json:"revisions"`
+	// DeleteMessages ob brisanju obvestila izbriše tudi njegova sporočila na Discordu.
+	DeleteMessages bool `json:"delete_messages"`
+	// Archive je datoteka JSON Lines, v katero pred brisanjem zapišemo izbrisane vrstice.
+	Archive string `json:"archive"`
+}
+
+// Schedule določa, kdaj preverjamo SharePoint. Če je nastavljen Cron, se IntervalMinutes ne upošteva.
+type Schedule struct {
+	// IntervalMinutes je razmik med dvema preverjanjema.
+	IntervalMinutes int `json:"interval_minutes"`
+	// Cron so izrazi cron s petimi polji v časovnem pasu konfiguracije, npr. "*/5 7-15 * * 1-5" in
+	// "0 0-6,16-23 * * *" za preverjanje vsakih 5 minut med poukom in vsako uro ponoči. Velja najbližji.
+	Cron []string `json:"cron"`
+	// JitterSeconds je največji naključni zamik posameznega preverjanja.
+	JitterSeconds int `json:"jitter_seconds"`
+}
+
+// Tracing določa izvoz sledi OpenTelemetry prek OTLP/HTTP. Brez naslova se sledi ne izvažajo,
+// razen če je nastavljena spremenljivka OTEL_EXPORTER_OTLP_ENDPOINT.
+type Tracing struct {
+	// Endpoint je naslov zbiralnika, npr. http://localhost:4318.
+	Endpoint string `json:"endpoint"`
+	// SampleRatio je delež preverjanj in dostav, ki jih sledimo (0 pomeni vse).
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
 type Config struct {
 	DatabaseName                string   `json:"database_name"`
 	DatabaseConfig              string   `json:"database_config"`
@@ -13,6 +118,88 @@ type Config struct {
 	MicrosoftOAUTH2Secret       string   `json:"ms_oauth2_secret"`
 	MicrosoftOAUTH2RefreshToken string   `json:"ms_oauth2_refresh_token"`
 	Webhooks                    []string `json:"webhooks"`
+	Targets                     []Target `json:"targets"`
+	OutboxMaxAttempts           int      `json:"outbox_max_attempts"`
+	Lists                       []string `json:"lists"`
+	// Routes so pravila usmerjanja. Brez pravil vsa obvestila prejmejo vsi cilji.
+	Routes    []Route   `json:"routes"`
+	Timezone  string    `json:"timezone"`
+	Retention Retention `json:"retention"`
+	// HTTPAddr je naslov strežnika za /healthz, /readyz, /status in /search.
+	HTTPAddr string `json:"http_addr"`
+	// SyncMaxAgeMinutes je največja starost zadnjega uspešnega preverjanja, da je /readyz še uspešen.
+	SyncMaxAgeMinutes int      `json:"sync_max_age_minutes"`
+	Tracing           Tracing  `json:"tracing"`
+	Schedule          Schedule `json:"schedule"`
+	// AdminToken je žeton za /admin/*, /status in /search. Brez žetona so te poti dostopne le z lokalnega naslova.
+	AdminToken string `json:"admin_token"`
+}
+
+// GetLocation vrne časovni pas konfiguracije. GetConfig ga preveri ob nalaganju.
+func (config Config) GetLocation() *time.Location {
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
+
+func (config Config) GetLists() []string {
+	if len(config.Lists) == 0 {
+		return []string{DefaultList}
+	}
+	return config.Lists
+}
+
+// WebhookID vrne ID Discord webhooka (https://discord.com/api/webhooks/<id>/<token>).
+func WebhookID(webhook string) string {
+	parts := strings.Split(strings.TrimSuffix(webhook, "/"), "/")
+	if len(parts) < 2 {
+		return webhook
+	}
+	return parts[len(parts)-2]
+}
+
+// GetTargets vrne vse cilje, vključno s tistimi, ki so podani kot navadni webhooki.
+func (config Config) GetTargets() []Target {
+	targets := make([]Target, 0)
+	for _, target := range config.Targets {
+		if target.ID == "" {
+			target.ID = WebhookID(target.Webhook)
+		}
+		if target.OnDeleted == "" {
+			target.OnDeleted = OnDeletedRemove
+		}
+		if target.Mode == "" {
+			target.Mode = ModeMessage
+		}
+		if target.EditPolicy == "" {
+			target.EditPolicy = EditPolicyEdit
+		}
+		if target.DigestAt == "" {
+			target.DigestAt = "07:00"
+		}
+		targets = append(targets, target)
+	}
+	for _, webhook := range config.Webhooks {
+		targets = append(targets, Target{
+			ID:         WebhookID(webhook),
+			Webhook:    webhook,
+			OnDeleted:  OnDeletedRemove,
+			Mode:       ModeMessage,
+			EditPolicy: EditPolicyEdit,
+		})
+	}
+	return targets
+}
+
+func (config Config) GetTarget(id string) (Target, bool) {
+	for _, target := range config.GetTargets() {
+		if target.ID == id {
+			return target, true
+		}
+	}
+	return Target{}, false
 }
 
 func GetConfig() (Config, error) {
@@ -27,6 +214,11 @@ func GetConfig() (Config, error) {
 			MicrosoftOAUTH2RefreshToken: "",
 			MicrosoftOAUTH2Secret:       "",
 			Webhooks:                    make([]string, 0),
+			Targets:                     make([]Target, 0),
+			OutboxMaxAttempts:           8,
+			Lists:                       []string{DefaultList},
+			Routes:                      make([]Route, 0),
+			Timezone:                    "Europe/Ljubljana",
 		})
 		if err != nil {
 			return config, err
@@ -44,6 +236,36 @@ func GetConfig() (Config, error) {
 	if err != nil {
 		return config, err
 	}
+	if config.OutboxMaxAttempts <= 0 {
+		config.OutboxMaxAttempts = 8
+	}
+	if config.Timezone == "" {
+		config.Timezone = "Europe/Ljubljana"
+	}
+	_, err = time.LoadLocation(config.Timezone)
+	if err != nil {
+		return config, fmt.Errorf("invalid timezone %q: %w", config.Timezone, err)
+	}
+	for _, target := range config.GetTargets() {
+		if target.Window == nil {
+			continue
+		}
+		for _, value := range []string{target.Window.Start, target.Window.End} {
+			_, err = time.Parse("15:04", value)
+			if err != nil {
+				return config, fmt.Errorf("invalid window for target %q: %w", target.ID, err)
+			}
+		}
+	}
+	if config.HTTPAddr == "" {
+		config.HTTPAddr = ":8080"
+	}
+	if config.SyncMaxAgeMinutes <= 0 {
+		config.SyncMaxAgeMinutes = 150
+	}
+	if config.Schedule.IntervalMinutes <= 0 {
+		config.Schedule.IntervalMinutes = 60
+	}
 	return config, err
 }
 
@@ -0,0 +1,348 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestContractSQLite preveri implementacijo SQL na SQLite v pomnilniku.
+func TestContractSQLite(t *testing.T) {
+	database, err := NewSQL("sqlite3", ":memory:", zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// vsaka povezava na :memory: ima svojo bazo
+	database.(*sqlImpl).db.SetMaxOpenConns(1)
+	testContract(t, database)
+}
+
+// TestContractPostgres preveri implementacijo SQL na prazni bazi Postgres iz SHAREPOINTBOT_TEST_POSTGRES.
+func TestContractPostgres(t *testing.T) {
+	dsn := os.Getenv("SHAREPOINTBOT_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("SHAREPOINTBOT_TEST_POSTGRES is not set")
+	}
+	database, err := NewSQL("postgres", dsn, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testContract(t, database)
+}
+
+// testContract preveri, da se implementacija SQL na danem gonilniku obnaša enako kot na ostalih.
+// Zaženemo ga na prazni bazi, ker vanjo zapiše testne podatke.
+func testContract(t *testing.T, database SQL) {
+	err := database.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := database.GetSharepointNotifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := database.GetDeliveryTargetIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(existing) != 0 || len(targets) != 0 {
+		t.Fatal("contract database is not empty")
+	}
+
+	check := func(name string, ok bool, got any) {
+		t.Helper()
+		if !ok {
+			t.Errorf("%s: got %+v", name, got)
+		}
+	}
+	must := func(name string, err error) bool {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		return err == nil
+	}
+
+	status, err := database.MigrationStatus()
+	if must("migration status", err) {
+		for _, s := range status {
+			check(fmt.Sprintf("migration %d applied", s.Version), s.Applied, s)
+		}
+	}
+
+	// obvestila
+	notifications := []SharepointNotification{
+		{ID: "contract-1", Name: "Prvo", Description: "Besedilo z **č, š in ž**", CreatedOn: 1700000000, ModifiedOn: 1700000100,
+			CreatedBy: "a@example.com", ModifiedBy: "b@example.com", ExpiresOn: 0, HasAttachments: true, ListID: "list",
+			ContentType: "Obvestilo", Fields: `{"Title":"Prvo","Razred":"1.a"}`, WebURL: "https://example.com/1", UpdatedOn: 1700001000},
+		{ID: "contract-2", Name: "Drugo", CreatedOn: 1700000200, ModifiedOn: 1700000300, ExpiresOn: 1700000500, Fields: "{}", UpdatedOn: 1700000300},
+		{ID: "contract-3", Name: "Tretje", Description: "Šolski izlet v Piran za 2.a", CreatedOn: 1700000050, ModifiedOn: 1700000400, ExpiresOn: 1800000000, Fields: "{}",
+			PreviousName: "Staro", PreviousDescription: "Staro besedilo", PreviousExpiresOn: 1700000600, UpdatedOn: 1700000450},
+	}
+	for _, n := range notifications {
+		must("insert notification "+n.ID, database.InsertSharepointNotification(n))
+	}
+	n, err := database.GetSharepointNotification("contract-1")
+	if must("get notification", err) {
+		check("notification round trip", reflect.DeepEqual(n, notifications[0]), n)
+	}
+	n, err = database.GetSharepointNotification("contract-3")
+	if must("get notification with previous version", err) {
+		check("previous version round trip", reflect.DeepEqual(n, notifications[2]), n)
+	}
+	_, err = database.GetSharepointNotification("contract-missing")
+	check("missing notification returns sql.ErrNoRows", errors.Is(err, sql.ErrNoRows), err)
+
+	all, err := database.GetSharepointNotifications()
+	if must("get notifications", err) {
+		check("notifications ordered by modified_on", ids(all) == "contract-1,contract-2,contract-3", ids(all))
+	}
+	active, err := database.GetActiveSharepointNotifications(1700001000)
+	if must("get active notifications", err) {
+		check("active notifications ordered by created_on", ids(active) == "contract-1,contract-3", ids(active))
+	}
+	between, err := database.GetSharepointNotificationsUpdatedBetween(1700000300, 1700001000)
+	if must("get notifications updated between", err) {
+		check("updated between uses updated_on, excludes from and includes to", ids(between) == "contract-1,contract-3", ids(between))
+	}
+
+	updated := notifications[0]
+	updated.Name = "Prvo (posodobljeno)"
+	updated.ModifiedOn = 1700000700
+	updated.CreatedOn = 1
+	updated.CreatedBy = "c@example.com"
+	updated.ListID = "other"
+	updated.PreviousName = notifications[0].Name
+	updated.PreviousExpiresOn = 1700000800
+	updated.UpdatedOn = 1700001100
+	must("update notification", database.UpdateSharepointNotification(updated))
+	n, err = database.GetSharepointNotification("contract-1")
+	if must("get updated notification", err) {
+		updated.CreatedOn = notifications[0].CreatedOn
+		updated.CreatedBy = notifications[0].CreatedBy
+		updated.ListID = notifications[0].ListID
+		check("update keeps created_on, created_by and list_id", reflect.DeepEqual(n, updated), n)
+	}
+
+	must("delete notification", database.DeleteSharepointNotification("contract-2"))
+	_, err = database.GetSharepointNotification("contract-2")
+	check("deleted notification is gone", errors.Is(err, sql.ErrNoRows), err)
+
+	// iskanje, brez indeksa (SQLite brez FTS5) LIKE ne loči strešic in ne razvršča
+	index := true
+	if impl, ok := database.(*sqlImpl); ok && impl.dialect == dialectSQLite {
+		index, err = impl.hasSearchIndex()
+		must("search index", err)
+	}
+	queries := map[string]string{
+		"izlet":        "contract-3",
+		"2.a":          "contract-3",
+		"posodobljeno": "contract-1",
+		"drugo":        "",
+		"":             "",
+	}
+	if index {
+		queries["solski izlet"] = "contract-3"
+		queries["ŠOLSKI"] = "contract-3"
+	}
+	for query, expected := range queries {
+		results, err := database.SearchSharepointNotifications(query, 10)
+		if !must("search "+query, err) {
+			continue
+		}
+		found := make([]SharepointNotification, 0)
+		for _, result := range results {
+			found = append(found, result.SharepointNotification)
+		}
+		check(fmt.Sprintf("search %q", query), ids(found) == expected, ids(found))
+		if index && expected == "contract-3" && len(results) != 0 {
+			check(fmt.Sprintf("search %q snippet", query), strings.Contains(results[0].Snippet, "**") && results[0].Rank > 0, results[0])
+		}
+	}
+
+	// outbox
+	entry := OutboxEntry{ID: "contract-entry", NotificationID: "contract-1", TargetID: "target-1", Action: OutboxActionRepost,
+		Status: OutboxStatusPending, NextAttemptOn: 1700000000, CreatedOn: 1700000000, UpdatedOn: 1700000000, Summary: "Povzetek"}
+	must("insert outbox entry", database.InsertOutboxEntry(entry))
+	must("insert outbox entry without id", database.InsertOutboxEntry(OutboxEntry{NotificationID: "contract-3", TargetID: "target-2",
+		Action: OutboxActionPost, Status: OutboxStatusPending, NextAttemptOn: 1700009999, CreatedOn: 1700000001, UpdatedOn: 1700000001}))
+	e, err := database.GetOutboxEntry("contract-entry")
+	if must("get outbox entry", err) {
+		check("outbox entry round trip", reflect.DeepEqual(e, entry), e)
+	}
+	due, err := database.GetDueOutboxEntries(1700005000)
+	if must("get due outbox entries", err) {
+		check("due entries respect next_attempt_on", len(due) == 1 && due[0].ID == "contract-entry", due)
+	}
+	forTarget, err := database.GetOutboxEntriesForTarget("target-2")
+	if must("get outbox entries for target", err) {
+		check("generated outbox id", len(forTarget) == 1 && forTarget[0].ID != "", forTarget)
+	}
+
+	entry.Status = OutboxStatusDone
+	entry.Attempts = 2
+	entry.MessageID = "message-1"
+	entry.LastError = "napaka"
+	entry.UpdatedOn = 1700000900
+	must("update outbox entry", database.UpdateOutboxEntry(entry))
+	byStatus, err := database.GetOutboxEntriesByStatus(OutboxStatusDone)
+	if must("get outbox entries by status", err) {
+		check("updated outbox entry", len(byStatus) == 1 && reflect.DeepEqual(byStatus[0], entry), byStatus)
+	}
+	forNotification, err := database.GetOutboxEntriesForNotification("contract-1")
+	if must("get outbox entries for notification", err) {
+		check("outbox entries for notification", len(forNotification) == 1, forNotification)
+	}
+
+	// dostave
+	delivery := Delivery{NotificationID: "contract-1", TargetID: "target-1", MessageID: "message-1", Status: DeliveryStatusPosted,
+		RenderedHash: "hash-1", CreatedOn: 1700000000, UpdatedOn: 1700000000,
+		PostedName: "Prvo", PostedDescription: "Besedilo", PostedExpiresOn: 1700000500}
+	must("insert delivery", database.UpsertDelivery(delivery))
+	changed := delivery
+	changed.MessageID = "message-2"
+	changed.RenderedHash = "hash-2"
+	changed.PostedName = "Prvo (ponovno)"
+	changed.PostedExpiresOn = 1700000600
+	changed.CreatedOn = 1700000999
+	changed.UpdatedOn = 1700000999
+	must("upsert delivery", database.UpsertDelivery(changed))
+	d, err := database.GetDelivery("contract-1", "target-1")
+	if must("get delivery", err) {
+		changed.CreatedOn = delivery.CreatedOn
+		check("upsert keeps created_on", reflect.DeepEqual(d, changed), d)
+	}
+	must("insert existing delivery", database.InsertDelivery(delivery))
+	d, err = database.GetDelivery("contract-1", "target-1")
+	if must("get delivery after insert", err) {
+		check("insert keeps existing delivery", reflect.DeepEqual(d, changed), d)
+	}
+	must("insert second delivery", database.UpsertDelivery(Delivery{NotificationID: "contract-3", TargetID: "target-1",
+		Status: DeliveryStatusPosted, CreatedOn: 1700000001, UpdatedOn: 1700000001}))
+	must("update deliveries for target", database.UpdateDeliveriesStatusForTarget("target-1", DeliveryStatusPosted, DeliveryStatusDetached))
+	forTargetDeliveries, err := database.GetDeliveriesForTarget("target-1")
+	if must("get deliveries for target", err) {
+		check("deliveries detached", len(forTargetDeliveries) == 2 && forTargetDeliveries[0].Status == DeliveryStatusDetached &&
+			forTargetDeliveries[1].Status == DeliveryStatusDetached, forTargetDeliveries)
+	}
+	targets, err = database.GetDeliveryTargetIDs()
+	if must("get delivery target ids", err) {
+		check("delivery target ids", reflect.DeepEqual(targets, []string{"target-1"}), targets)
+	}
+	must("delete delivery", database.DeleteDelivery("contract-3", "target-1"))
+	forNotificationDeliveries, err := database.GetDeliveriesForNotification("contract-3")
+	if must("get deliveries for notification", err) {
+		check("deleted delivery is gone", len(forNotificationDeliveries) == 0, forNotificationDeliveries)
+	}
+
+	// različice
+	revision := NotificationRevision{NotificationID: "contract-1", Version: "1.0", Title: "Prvo", HTML: "<p>Prvo</p>", Markdown: "Prvo",
+		ModifiedBy: "a@example.com", ModifiedOn: 1700000100, ETag: "etag", CreatedOn: 1700000100}
+	inserted, err := database.InsertNotificationRevision(revision)
+	if must("insert revision", err) {
+		check("new revision is inserted", inserted, inserted)
+	}
+	duplicate := revision
+	duplicate.Title = "Podvojeno"
+	inserted, err = database.InsertNotificationRevision(duplicate)
+	if must("insert duplicate revision", err) {
+		check("duplicate revision is not inserted", !inserted, inserted)
+	}
+	_, err = database.InsertNotificationRevision(NotificationRevision{NotificationID: "contract-1", Version: "2.0",
+		ModifiedOn: 1700000050, CreatedOn: 1700000100})
+	must("insert second revision", err)
+	r, err := database.GetNotificationRevision("contract-1", "1.0")
+	if must("get revision", err) {
+		check("duplicate revision is ignored", reflect.DeepEqual(r, revision), r)
+	}
+	revisions, err := database.GetNotificationRevisions("contract-1")
+	if must("get revisions", err) {
+		check("revisions ordered by modified_on", len(revisions) == 2 && revisions[0].Version == "2.0", revisions)
+	}
+
+	// izbrisana obvestila
+	must("upsert pruned notification", database.UpsertPrunedNotification(PrunedNotification{ID: "contract-pruned", ModifiedOn: 1700000100, CreatedOn: 1700000200}))
+	must("upsert pruned notification again", database.UpsertPrunedNotification(PrunedNotification{ID: "contract-pruned", ModifiedOn: 1700000300, CreatedOn: 1700000400}))
+	pruned, err := database.GetPrunedNotification("contract-pruned")
+	if must("get pruned notification", err) {
+		check("upsert updates modified_on and keeps created_on", pruned == PrunedNotification{ID: "contract-pruned", ModifiedOn: 1700000300, CreatedOn: 1700000200}, pruned)
+	}
+	must("delete pruned notification", database.DeletePrunedNotification("contract-pruned"))
+	_, err = database.GetPrunedNotification("contract-pruned")
+	check("deleted pruned notification is gone", errors.Is(err, sql.ErrNoRows), err)
+
+	// pregledi
+	digest := Digest{ID: "contract-digest", TargetID: "target-1", MessageID: "message-3", PeriodStart: 1700000000, PeriodEnd: 1700086400,
+		NotificationIDs: `["contract-1"]`, RenderedHash: "hash", CreatedOn: 1700086400, UpdatedOn: 1700086400}
+	must("insert digest", database.InsertDigest(Digest{TargetID: "target-1", PeriodStart: 1699913600, PeriodEnd: 1700000000,
+		NotificationIDs: "[]", CreatedOn: 1700000000, UpdatedOn: 1700000000}))
+	must("insert latest digest", database.InsertDigest(digest))
+	digest.RenderedHash = "hash-2"
+	digest.UpdatedOn = 1700090000
+	must("update digest", database.UpdateDigest(digest))
+	latest, err := database.GetLatestDigest("target-1")
+	if must("get latest digest", err) {
+		check("latest digest by period_end", reflect.DeepEqual(latest, digest), latest)
+	}
+	byID, err := database.GetDigest("contract-digest")
+	if must("get digest", err) {
+		check("digest by id", reflect.DeepEqual(byID, digest), byID)
+	}
+	_, err = database.GetLatestDigest("target-missing")
+	check("missing digest returns sql.ErrNoRows", errors.Is(err, sql.ErrNoRows), err)
+
+	// transakcije
+	errRollback := errors.New("rollback")
+	err = database.WithTx(context.Background(), func(tx SQL) error {
+		err := tx.InsertSharepointNotification(SharepointNotification{ID: "contract-rollback", Fields: "{}"})
+		if err != nil {
+			return err
+		}
+		return tx.WithTx(context.Background(), func(tx SQL) error {
+			err := tx.UpsertDelivery(Delivery{NotificationID: "contract-rollback", TargetID: "target-1"})
+			if err != nil {
+				return err
+			}
+			return errRollback
+		})
+	})
+	check("transaction returns error", errors.Is(err, errRollback), err)
+	_, err = database.GetSharepointNotification("contract-rollback")
+	check("rolled back notification is gone", errors.Is(err, sql.ErrNoRows), err)
+	_, err = database.GetDelivery("contract-rollback", "target-1")
+	check("rolled back delivery is gone", errors.Is(err, sql.ErrNoRows), err)
+
+	err = database.WithTx(context.Background(), func(tx SQL) error {
+		err := tx.InsertSharepointNotification(SharepointNotification{ID: "contract-commit", Fields: "{}"})
+		if err != nil {
+			return err
+		}
+		return tx.UpsertDelivery(Delivery{NotificationID: "contract-commit", TargetID: "target-1"})
+	})
+	must("commit transaction", err)
+	_, err = database.GetDelivery("contract-commit", "target-1")
+	must("committed delivery", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = database.WithContext(ctx).GetSharepointNotification("contract-commit")
+	check("cancelled context", errors.Is(err, context.Canceled), err)
+
+}
+
+func ids(notifications []SharepointNotification) string {
+	s := ""
+	for i, n := range notifications {
+		if i != 0 {
+			s += ","
+		}
+		s += n.ID
+	}
+	return s
+}
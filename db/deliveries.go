@@ -0,0 +1,117 @@
+package db
+
+import "fmt"
+
+const (
+	DeliveryStatusPosted = "posted"
+	// DeliveryStatusDetached označuje dostavo za cilj, ki ni več v konfiguraciji.
+	DeliveryStatusDetached = "detached"
+	// DeliveryStatusRemoved označuje sporočilo, ki ga je moderator izbrisal in ga ne objavljamo znova.
+	DeliveryStatusRemoved = "removed"
+)
+
+type Delivery struct {
+	NotificationID string `db:"notification_id"`
+	TargetID       string `db:"target_id"`
+	MessageID      string `db:"message_id"`
+	Status         string `db:"status"`
+	RenderedHash   string `db:"rendered_hash"`
+	CreatedOn      int    `db:"created_on"`
+	UpdatedOn      int    `db:"updated_on"`
+	// različica obvestila ob objavi sporočila, osnova za polje "Spremembe" ob urejanjih
+	PostedName        string `db:"posted_name"`
+	PostedDescription string `db:"posted_description"`
+	PostedExpiresOn   int    `db:"posted_expires_on"`
+}
+
+func (db *sqlImpl) GetDelivery(notificationID string, targetID string) (delivery Delivery, err error) {
+	err = db.get(&delivery, fmt.Sprintf("SELECT %s FROM deliveries WHERE notification_id=? AND target_id=?", db.dialect.columns(delivery)), notificationID, targetID)
+	return delivery, err
+}
+
+func (db *sqlImpl) GetDeliveriesForNotification(notificationID string) (deliveries []Delivery, err error) {
+	err = db.sel(&deliveries, fmt.Sprintf("SELECT %s FROM deliveries WHERE notification_id=? ORDER BY created_on ASC", db.dialect.columns(Delivery{})), notificationID)
+	return deliveries, err
+}
+
+func (db *sqlImpl) GetDeliveriesForTarget(targetID string) (deliveries []Delivery, err error) {
+	err = db.sel(&deliveries, fmt.Sprintf("SELECT %s FROM deliveries WHERE target_id=? ORDER BY created_on ASC", db.dialect.columns(Delivery{})), targetID)
+	return deliveries, err
+}
+
+func (db *sqlImpl) GetDeliveryTargetIDs() (targetIDs []string, err error) {
+	err = db.sel(&targetIDs, "SELECT DISTINCT target_id FROM deliveries")
+	return targetIDs, err
+}
+
+func (db *sqlImpl) UpdateDeliveriesStatusForTarget(targetID string, from string, to string) error {
+	return db.exec(`UPDATE deliveries SET status=? WHERE target_id=? AND status=?`, to, targetID, from)
+}
+
+func (db *sqlImpl) UpsertDelivery(delivery Delivery) (err error) {
+	err = db.namedExec(
+		`INSERT INTO deliveries
+	(notification_id,
+	 target_id,
+	 message_id,
+	 status,
+	 rendered_hash,
+	 created_on,
+	 updated_on,
+	 posted_name,
+	 posted_description,
+	 posted_expires_on)
+VALUES (:notification_id,
+		:target_id,
+		:message_id,
+		:status,
+		:rendered_hash,
+		:created_on,
+		:updated_on,
+		:posted_name,
+		:posted_description,
+		:posted_expires_on)
+ON CONFLICT (notification_id, target_id) DO UPDATE SET
+	message_id=excluded.message_id,
+	status=excluded.status,
+	rendered_hash=excluded.rendered_hash,
+	updated_on=excluded.updated_on,
+	posted_name=excluded.posted_name,
+	posted_description=excluded.posted_description,
+	posted_expires_on=excluded.posted_expires_on
+`, delivery)
+	return err
+}
+
+// InsertDelivery doda dostavo, če je za obvestilo in cilj še ni. Obstoječe dostave ne spremeni.
+func (db *sqlImpl) InsertDelivery(delivery Delivery) (err error) {
+	err = db.namedExec(
+		`INSERT INTO deliveries
+	(notification_id,
+	 target_id,
+	 message_id,
+	 status,
+	 rendered_hash,
+	 created_on,
+	 updated_on,
+	 posted_name,
+	 posted_description,
+	 posted_expires_on)
+VALUES (:notification_id,
+		:target_id,
+		:message_id,
+		:status,
+		:rendered_hash,
+		:created_on,
+		:updated_on,
+		:posted_name,
+		:posted_description,
+		:posted_expires_on)
+ON CONFLICT (notification_id, target_id) DO NOTHING
+`, delivery)
+	return err
+}
+
+func (db *sqlImpl) DeleteDelivery(notificationID string, targetID string) error {
+	return db.exec(`DELETE FROM deliveries WHERE notification_id=? AND target_id=?`, notificationID, targetID)
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite3"
+	dialectPostgres dialect = "postgres"
+)
+
+// časovni stolpci so v Go in v SQLite Unix sekunde, v Postgresu pa TIMESTAMPTZ
+var timestampColumns = map[string]bool{
+	"created_on":          true,
+	"modified_on":         true,
+	"updated_on":          true,
+	"expires_on":          true,
+	"previous_expires_on": true,
+	"posted_expires_on":   true,
+	"next_attempt_on":     true,
+	"period_start":        true,
+	"period_end":          true,
+}
+
+func newDialect(driver string) dialect {
+	if driver == "postgres" || driver == "pgx" {
+		return dialectPostgres
+	}
+	return dialectSQLite
+}
+
+// ddl v migracijah zamenja {timestamp}, {timestamp_zero} in {json} s tipi dialekta.
+func (d dialect) ddl(query string) string {
+	if d == dialectPostgres {
+		return strings.NewReplacer("{timestamp_zero}", "to_timestamp(0)", "{timestamp}", "TIMESTAMPTZ", "{json}", "JSONB").Replace(query)
+	}
+	return strings.NewReplacer("{timestamp_zero}", "0", "{timestamp}", "INTEGER", "{json}", "JSON").Replace(query)
+}
+
+// ts pretvori Unix sekunde (parameter ali izraz) v časovni tip stolpca.
+func (d dialect) ts(expr string) string {
+	if d == dialectPostgres {
+		return fmt.Sprintf("to_timestamp(%s)", expr)
+	}
+	return expr
+}
+
+// epoch pretvori časovni stolpec nazaj v Unix sekunde.
+func (d dialect) epoch(column string) string {
+	if d == dialectPostgres {
+		return fmt.Sprintf("CAST(EXTRACT(EPOCH FROM %s) AS BIGINT)", column)
+	}
+	return column
+}
+
+// columns vrne seznam stolpcev za SELECT glede na oznake db v strukturi.
+func (d dialect) columns(model any) string {
+	t := reflect.TypeOf(model)
+	columns := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("db")
+		if name == "" || name == "-" {
+			continue
+		}
+		if timestampColumns[name] {
+			columns = append(columns, fmt.Sprintf("%s AS %s", d.epoch(name), name))
+			continue
+		}
+		columns = append(columns, name)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// named v poimenovani poizvedbi pretvori parametre časovnih stolpcev (:created_on ...).
+func (d dialect) named(query string) string {
+	if d == dialectPostgres {
+		for column := range timestampColumns {
+			query = replaceParam(query, ":"+column, d.ts(":"+column))
+		}
+	}
+	return query
+}
+
+// replaceParam zamenja parameter le, kadar se ime ne nadaljuje (":created_on" ne sme zadeti ":created_on_x").
+func replaceParam(query string, param string, replacement string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(query, param)
+		if i < 0 {
+			b.WriteString(query)
+			return b.String()
+		}
+		end := i + len(param)
+		if end < len(query) && (query[end] == '_' || query[end] >= 'a' && query[end] <= 'z') {
+			b.WriteString(query[:end])
+			query = query[end:]
+			continue
+		}
+		b.WriteString(query[:i])
+		b.WriteString(replacement)
+		query = query[end:]
+	}
+}
+
+// context vrne kontekst poizvedbe, ki ima vedno rok.
+func (db *sqlImpl) context() (context.Context, context.CancelFunc) {
+	if _, ok := db.ctx.Deadline(); ok {
+		return context.WithCancel(db.ctx)
+	}
+	return context.WithTimeout(db.ctx, QueryTimeout)
+}
+
+func (db *sqlImpl) get(dest any, query string, args ...any) error {
+	ctx, cancel := db.context()
+	defer cancel()
+	return db.ex.GetContext(ctx, dest, db.ex.Rebind(query), args...)
+}
+
+func (db *sqlImpl) sel(dest any, query string, args ...any) error {
+	ctx, cancel := db.context()
+	defer cancel()
+	return db.ex.SelectContext(ctx, dest, db.ex.Rebind(query), args...)
+}
+
+func (db *sqlImpl) exec(query string, args ...any) error {
+	ctx, cancel := db.context()
+	defer cancel()
+	_, err := db.ex.ExecContext(ctx, db.ex.Rebind(query), args...)
+	return err
+}
+
+func (db *sqlImpl) namedExec(query string, arg any) error {
+	ctx, cancel := db.context()
+	defer cancel()
+	_, err := db.ex.NamedExecContext(ctx, db.dialect.named(query), arg)
+	return err
+}
+
+// namedExecRows je namedExec, ki vrne število spremenjenih vrstic.
+func (db *sqlImpl) namedExecRows(query string, arg any) (int64, error) {
+	ctx, cancel := db.context()
+	defer cancel()
+	result, err := db.ex.NamedExecContext(ctx, db.dialect.named(query), arg)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,63 @@
+package db
+
+import "fmt"
+
+type Digest struct {
+	ID              string `db:"id"`
+	TargetID        string `db:"target_id"`
+	MessageID       string `db:"message_id"`
+	PeriodStart     int    `db:"period_start"`
+	PeriodEnd       int    `db:"period_end"`
+	NotificationIDs string `db:"notification_ids"`
+	RenderedHash    string `db:"rendered_hash"`
+	CreatedOn       int    `db:"created_on"`
+	UpdatedOn       int    `db:"updated_on"`
+}
+
+func (db *sqlImpl) GetDigest(id string) (digest Digest, err error) {
+	err = db.get(&digest, fmt.Sprintf("SELECT %s FROM digests WHERE id=?", db.dialect.columns(digest)), id)
+	return digest, err
+}
+
+func (db *sqlImpl) GetLatestDigest(targetID string) (digest Digest, err error) {
+	err = db.get(&digest, fmt.Sprintf("SELECT %s FROM digests WHERE target_id=? ORDER BY period_end DESC LIMIT 1", db.dialect.columns(digest)), targetID)
+	return digest, err
+}
+
+func (db *sqlImpl) InsertDigest(digest Digest) (err error) {
+	if digest.ID == "" {
+		digest.ID = newID()
+	}
+	err = db.namedExec(
+		`INSERT INTO digests
+	(id,
+	 target_id,
+	 message_id,
+	 period_start,
+	 period_end,
+	 notification_ids,
+	 rendered_hash,
+	 created_on,
+	 updated_on)
+VALUES (:id,
+		:target_id,
+		:message_id,
+		:period_start,
+		:period_end,
+		:notification_ids,
+		:rendered_hash,
+		:created_on,
+		:updated_on)
+`, digest)
+	return err
+}
+
+func (db *sqlImpl) UpdateDigest(digest Digest) error {
+	return db.namedExec(
+		`UPDATE digests SET
+			message_id=:message_id,
+			rendered_hash=:rendered_hash,
+			updated_on=:updated_on
+WHERE id=:id`,
+		digest)
+}
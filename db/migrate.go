@@ -0,0 +1,98 @@
+package db
+
+import (
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sqlx.Tx, d dialect) error
+}
+
+type MigrationStatus struct {
+	Version   int    `db:"version"`
+	Name      string `db:"name"`
+	AppliedOn int    `db:"applied_on"`
+	Applied   bool   `db:"-"`
+}
+
+const migrationsSchema = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version					INTEGER        PRIMARY KEY,
+	name					VARCHAR,
+	applied_on				INTEGER
+);
+`
+
+func (db *sqlImpl) appliedMigrations() (map[int]MigrationStatus, error) {
+	_, err := db.db.Exec(migrationsSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []MigrationStatus
+	err = db.db.Select(&rows, "SELECT * FROM schema_migrations ORDER BY version ASC")
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]MigrationStatus)
+	for _, row := range rows {
+		row.Applied = true
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// MigrationStatus vrne vse znane migracije in ali so bile že izvedene.
+func (db *sqlImpl) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0)
+	for _, m := range migrations {
+		s, ok := applied[m.version]
+		if !ok {
+			s = MigrationStatus{Version: m.version, Name: m.name}
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Migrate izvede vse manjkajoče migracije, vsako v svoji transakciji.
+func (db *sqlImpl) Migrate() error {
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		db.logger.Infow("applying migration", "version", m.version, "name", m.name)
+		tx, err := db.db.Beginx()
+		if err != nil {
+			return err
+		}
+		err = m.up(tx, db.dialect)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind("INSERT INTO schema_migrations (version, name, applied_on) VALUES (?, ?, ?)"), m.version, m.name, time.Now().Unix())
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return db.syncSearchIndex()
+}
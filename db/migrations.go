@@ -0,0 +1,416 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"strings"
+)
+
+type column struct {
+	name       string
+	definition string
+}
+
+// migrations so urejene po različicah in se nikoli ne spreminjajo, nove spremembe sheme se dodajo na konec.
+// Migracije so idempotentne, ker so jih starejše namestitve delno že izvedle brez sledenja različicam.
+var migrations = []migration{
+	{1, "create sharepoint_notifications", execMigration(`
+CREATE TABLE IF NOT EXISTS sharepoint_notifications (
+	id						VARCHAR(60)    PRIMARY KEY,
+	name					VARCHAR,
+	description				VARCHAR,
+	created_on				{timestamp},
+	modified_on				{timestamp},
+	message_ids				{json},
+	created_by				VARCHAR(100),
+	modified_by				VARCHAR(100),
+	expires_on				{timestamp},
+	has_attachments			BOOLEAN
+);`)},
+	{2, "create outbox", execMigration(`
+CREATE TABLE IF NOT EXISTS outbox (
+	id						VARCHAR(60)    PRIMARY KEY,
+	notification_id			VARCHAR(60),
+	target_id				VARCHAR(100),
+	action					VARCHAR(10),
+	message_id				VARCHAR(60),
+	status					VARCHAR(10),
+	attempts				INTEGER,
+	next_attempt_on			{timestamp},
+	last_error				VARCHAR,
+	created_on				{timestamp},
+	updated_on				{timestamp}
+);`)},
+	{3, "create deliveries from message_ids", migrateMessageIDs},
+	{4, "add routing columns to sharepoint_notifications", addColumnsMigration("sharepoint_notifications",
+		column{"list_id", "VARCHAR(60) DEFAULT ''"},
+		column{"content_type", "VARCHAR(100) DEFAULT ''"},
+		column{"fields", "{json} DEFAULT '{}'"},
+		column{"web_url", "VARCHAR DEFAULT ''"},
+	)},
+	{5, "create digests", execMigration(`
+CREATE TABLE IF NOT EXISTS digests (
+	id						VARCHAR(60)    PRIMARY KEY,
+	target_id				VARCHAR(100),
+	message_id				VARCHAR(60),
+	period_start			{timestamp},
+	period_end				{timestamp},
+	notification_ids		{json},
+	rendered_hash			VARCHAR(64),
+	created_on				{timestamp},
+	updated_on				{timestamp}
+);`)},
+	{6, "add summary to outbox", addColumnsMigration("outbox",
+		column{"summary", "VARCHAR DEFAULT ''"},
+	)},
+	{7, "add previous version to sharepoint_notifications", addColumnsMigration("sharepoint_notifications",
+		column{"previous_name", "VARCHAR DEFAULT ''"},
+		column{"previous_description", "VARCHAR DEFAULT ''"},
+		column{"previous_expires_on", "{timestamp} DEFAULT {timestamp_zero}"},
+	)},
+	{8, "create notification_revisions", execMigration(`
+CREATE TABLE IF NOT EXISTS notification_revisions (
+	notification_id			VARCHAR(60),
+	version					VARCHAR(20),
+	title					VARCHAR,
+	html					VARCHAR,
+	markdown				VARCHAR,
+	modified_by				VARCHAR(100),
+	modified_on				{timestamp},
+	etag					VARCHAR(100),
+	created_on				{timestamp},
+	PRIMARY KEY (notification_id, version)
+);`)},
+	{9, "use timestamptz and jsonb on postgres", convertPostgresTypes},
+	{10, "add full-text search", createSearchIndex},
+	{11, "add updated_on to sharepoint_notifications", addUpdatedOn},
+	{12, "create pruned_notifications", execMigration(`
+CREATE TABLE IF NOT EXISTS pruned_notifications (
+	id						VARCHAR(60)    PRIMARY KEY,
+	modified_on				{timestamp},
+	created_on				{timestamp}
+);`)},
+	{13, "add posted version to deliveries", addPostedVersion},
+	{14, "widen outbox action", widenOutboxAction},
+}
+
+func execMigration(query string) func(tx *sqlx.Tx, d dialect) error {
+	return func(tx *sqlx.Tx, d dialect) error {
+		_, err := tx.Exec(d.ddl(query))
+		return err
+	}
+}
+
+func hasColumn(tx *sqlx.Tx, d dialect, table string, column string) (bool, error) {
+	var count int
+	var err error
+	if d == dialectPostgres {
+		err = tx.Get(&count, "SELECT COUNT(*) FROM information_schema.columns WHERE table_name=$1 AND column_name=$2", table, column)
+	} else {
+		err = tx.Get(&count, "SELECT COUNT(*) FROM pragma_table_info($1) WHERE name=$2", table, column)
+	}
+	return count != 0, err
+}
+
+// epochColumn vrne izraz za branje časovnega stolpca v sekundah. Baze, ustvarjene v Postgresu pred
+// podporo dialektom, imajo do migracije 9 (convertPostgresTypes) časovne stolpce še kot INTEGER.
+func epochColumn(tx *sqlx.Tx, d dialect, table string, column string) (string, error) {
+	if d != dialectPostgres {
+		return column, nil
+	}
+	var dataType string
+	err := tx.Get(&dataType, "SELECT data_type FROM information_schema.columns WHERE table_schema=current_schema() AND table_name=$1 AND column_name=$2", table, column)
+	if err != nil {
+		return "", err
+	}
+	if dataType == "integer" || dataType == "bigint" {
+		return column, nil
+	}
+	return d.epoch(column), nil
+}
+
+func addColumnsMigration(table string, columns ...column) func(tx *sqlx.Tx, d dialect) error {
+	return func(tx *sqlx.Tx, d dialect) error {
+		for _, c := range columns {
+			exists, err := hasColumn(tx, d, table, c.name)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, c.name, d.ddl(c.definition)))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addUpdatedOn doda čas, ko smo obvestilo nazadnje shranili. Za obstoječa obvestila ga ne poznamo,
+// zato uporabimo modified_on.
+func addUpdatedOn(tx *sqlx.Tx, d dialect) error {
+	exists, err := hasColumn(tx, d, "sharepoint_notifications", "updated_on")
+	if err != nil || exists {
+		return err
+	}
+	err = addColumnsMigration("sharepoint_notifications", column{"updated_on", "{timestamp} DEFAULT {timestamp_zero}"})(tx, d)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE sharepoint_notifications SET updated_on=modified_on")
+	return err
+}
+
+// addPostedVersion doda različico obvestila ob objavi. Obstoječa sporočila so bila urejena na
+// trenutno različico, zato je ta osnova za naslednje spremembe.
+func addPostedVersion(tx *sqlx.Tx, d dialect) error {
+	exists, err := hasColumn(tx, d, "deliveries", "posted_name")
+	if err != nil || exists {
+		return err
+	}
+	err = addColumnsMigration("deliveries",
+		column{"posted_name", "VARCHAR DEFAULT ''"},
+		column{"posted_description", "VARCHAR DEFAULT ''"},
+		column{"posted_expires_on", "{timestamp} DEFAULT {timestamp_zero}"},
+	)(tx, d)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE deliveries SET
+	posted_name=(SELECT name FROM sharepoint_notifications n WHERE n.id=deliveries.notification_id),
+	posted_description=(SELECT description FROM sharepoint_notifications n WHERE n.id=deliveries.notification_id),
+	posted_expires_on=(SELECT expires_on FROM sharepoint_notifications n WHERE n.id=deliveries.notification_id)
+WHERE EXISTS (SELECT 1 FROM sharepoint_notifications n WHERE n.id=deliveries.notification_id)`)
+	return err
+}
+
+// widenOutboxAction poveča stolpec action za daljša dejanja (digest_edit). SQLite dolžine ne preverja.
+func widenOutboxAction(tx *sqlx.Tx, d dialect) error {
+	if d != dialectPostgres {
+		return nil
+	}
+	_, err := tx.Exec("ALTER TABLE outbox ALTER COLUMN action TYPE VARCHAR(20)")
+	return err
+}
+
+// migrateMessageIDs prenese stolpec message_ids (JSON seznam URL-jev webhookov z /messages/<id>)
+// v tabelo deliveries in stolpec odstrani, da v bazi ne hranimo žetonov webhookov.
+func migrateMessageIDs(tx *sqlx.Tx, d dialect) error {
+	_, err := tx.Exec(d.ddl(`
+CREATE TABLE IF NOT EXISTS deliveries (
+	notification_id			VARCHAR(60),
+	target_id				VARCHAR(100),
+	message_id				VARCHAR(60),
+	status					VARCHAR(10),
+	rendered_hash			VARCHAR(64),
+	created_on				{timestamp},
+	updated_on				{timestamp},
+	PRIMARY KEY (notification_id, target_id)
+);`))
+	if err != nil {
+		return err
+	}
+
+	exists, err := hasColumn(tx, d, "sharepoint_notifications", "message_ids")
+	if err != nil || !exists {
+		return err
+	}
+
+	modifiedOn, err := epochColumn(tx, d, "sharepoint_notifications", "modified_on")
+	if err != nil {
+		return err
+	}
+	var rows []struct {
+		ID         string `db:"id"`
+		MessageIDs string `db:"message_ids"`
+		ModifiedOn int    `db:"modified_on"`
+	}
+	err = tx.Select(&rows, fmt.Sprintf("SELECT id, COALESCE(message_ids, '[]') AS message_ids, %s AS modified_on FROM sharepoint_notifications", modifiedOn))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		var urls []string
+		err = json.Unmarshal([]byte(row.MessageIDs), &urls)
+		if err != nil {
+			return err
+		}
+		for _, url := range urls {
+			webhook, messageID, found := strings.Cut(url, "/messages/")
+			if !found {
+				continue
+			}
+			// ID webhooka (https://discord.com/api/webhooks/<id>/<token>) preberemo tu, da se migracija
+			// ne spremeni, če se spremeni config.WebhookID
+			targetID := webhook
+			if parts := strings.Split(strings.TrimSuffix(webhook, "/"), "/"); len(parts) >= 2 {
+				targetID = parts[len(parts)-2]
+			}
+			_, err = tx.NamedExec(d.named(
+				`INSERT INTO deliveries (notification_id, target_id, message_id, status, rendered_hash, created_on, updated_on)
+VALUES (:notification_id, :target_id, :message_id, :status, :rendered_hash, :created_on, :updated_on)
+ON CONFLICT (notification_id, target_id) DO NOTHING`),
+				Delivery{
+					NotificationID: row.ID,
+					TargetID:       targetID,
+					MessageID:      messageID,
+					Status:         DeliveryStatusPosted,
+					CreatedOn:      row.ModifiedOn,
+					UpdatedOn:      row.ModifiedOn,
+				})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec("ALTER TABLE sharepoint_notifications DROP COLUMN message_ids")
+	return err
+}
+
+// convertPostgresTypes pretvori stolpce baz, ustvarjenih v Postgresu pred podporo dialektom
+// (INTEGER in JSON kot v SQLite), v TIMESTAMPTZ in JSONB. Na SQLite ne naredi ničesar.
+func convertPostgresTypes(tx *sqlx.Tx, d dialect) error {
+	if d != dialectPostgres {
+		return nil
+	}
+
+	var columns []struct {
+		Table    string `db:"table_name"`
+		Column   string `db:"column_name"`
+		DataType string `db:"data_type"`
+	}
+	err := tx.Select(&columns, `SELECT table_name, column_name, data_type FROM information_schema.columns
+WHERE table_schema=current_schema() AND table_name IN ('sharepoint_notifications', 'outbox', 'deliveries', 'digests', 'notification_revisions')`)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range columns {
+		var statements []string
+		switch {
+		case timestampColumns[c.Column] && (c.DataType == "integer" || c.DataType == "bigint"):
+			statements = []string{
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", c.Table, c.Column),
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE TIMESTAMPTZ USING to_timestamp(%s)", c.Table, c.Column, c.Column),
+			}
+			if c.Column == "previous_expires_on" {
+				statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT to_timestamp(0)", c.Table, c.Column))
+			}
+		case (c.Column == "fields" || c.Column == "notification_ids") && c.DataType != "jsonb":
+			statements = []string{
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", c.Table, c.Column),
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE JSONB USING %s::jsonb", c.Table, c.Column, c.Column),
+			}
+			if c.Column == "fields" {
+				statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT '{}'", c.Table, c.Column))
+			}
+		}
+		for _, statement := range statements {
+			_, err = tx.Exec(statement)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createSearchIndex doda indeks za iskanje po naslovu in besedilu. V SQLite je to tabela FTS5, ki jo
+// posodabljajo sprožilci, v Postgresu pa generiran stolpec tsvector. Oba ne ločita strešic.
+func createSearchIndex(tx *sqlx.Tx, d dialect) error {
+	if d == dialectPostgres {
+		_, err := tx.Exec(`
+ALTER TABLE sharepoint_notifications ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', translate(lower(COALESCE(name, '')), 'čćšžđ', 'ccszd')), 'A') ||
+	setweight(to_tsvector('simple', translate(lower(COALESCE(description, '')), 'čćšžđ', 'ccszd')), 'B')
+) STORED;
+CREATE INDEX IF NOT EXISTS sharepoint_notifications_search ON sharepoint_notifications USING GIN (search);`)
+		return err
+	}
+
+	fts5, err := hasFTS5(tx)
+	if err != nil {
+		return err
+	}
+	// brez FTS5 iskanje uporablja LIKE, indeks ustvari syncSearchIndex ob zagonu z -tags sqlite_fts5
+	if !fts5 {
+		return nil
+	}
+
+	// id hranimo v tabeli FTS, ker se rowid tabele brez INTEGER PRIMARY KEY ob VACUUM lahko spremeni
+	_, err = tx.Exec(`
+CREATE VIRTUAL TABLE IF NOT EXISTS sharepoint_notifications_fts USING fts5(
+	id UNINDEXED,
+	name,
+	description,
+	tokenize='unicode61 remove_diacritics 2'
+);
+CREATE TRIGGER IF NOT EXISTS sharepoint_notifications_fts_insert AFTER INSERT ON sharepoint_notifications BEGIN
+	INSERT INTO sharepoint_notifications_fts (id, name, description) VALUES (new.id, new.name, new.description);
+END;
+CREATE TRIGGER IF NOT EXISTS sharepoint_notifications_fts_update AFTER UPDATE ON sharepoint_notifications BEGIN
+	DELETE FROM sharepoint_notifications_fts WHERE id=old.id;
+	INSERT INTO sharepoint_notifications_fts (id, name, description) VALUES (new.id, new.name, new.description);
+END;
+CREATE TRIGGER IF NOT EXISTS sharepoint_notifications_fts_delete AFTER DELETE ON sharepoint_notifications BEGIN
+	DELETE FROM sharepoint_notifications_fts WHERE id=old.id;
+END;
+DELETE FROM sharepoint_notifications_fts;
+INSERT INTO sharepoint_notifications_fts (id, name, description) SELECT id, name, description FROM sharepoint_notifications;`)
+	return err
+}
+
+func hasFTS5(q sqlx.Queryer) (bool, error) {
+	var fts5 bool
+	err := sqlx.Get(q, &fts5, "SELECT sqlite_compileoption_used('ENABLE_FTS5')")
+	return fts5, err
+}
+
+// syncSearchIndex uskladi indeks FTS5 z zmožnostmi SQLite. Binarka brez FTS5 odstrani sprožilce, ker bi
+// sicer vsak vpis obvestila spodletel (no such module: fts5), binarka s FTS5 pa indeks ustvari in napolni.
+func (db *sqlImpl) syncSearchIndex() error {
+	if db.dialect != dialectSQLite {
+		return nil
+	}
+	fts5, err := hasFTS5(db.db)
+	if err != nil {
+		return err
+	}
+	var triggers int
+	err = db.db.Get(&triggers, "SELECT COUNT(*) FROM sqlite_master WHERE type='trigger' AND name LIKE 'sharepoint_notifications_fts_%'")
+	if err != nil {
+		return err
+	}
+
+	if !fts5 {
+		db.logger.Warnw("sqlite is built without FTS5, search falls back to LIKE (build with -tags sqlite_fts5)")
+		if triggers == 0 {
+			return nil
+		}
+		_, err = db.db.Exec(`
+DROP TRIGGER IF EXISTS sharepoint_notifications_fts_insert;
+DROP TRIGGER IF EXISTS sharepoint_notifications_fts_update;
+DROP TRIGGER IF EXISTS sharepoint_notifications_fts_delete;`)
+		return err
+	}
+	if triggers == 3 {
+		return nil
+	}
+
+	db.logger.Infow("creating search index")
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	err = createSearchIndex(tx, db.dialect)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package db
+
+import "fmt"
+
+type NotificationRevision struct {
+	NotificationID string `db:"notification_id"`
+	Version        string `db:"version"`
+	Title          string `db:"title"`
+	HTML           string `db:"html"`
+	Markdown       string `db:"markdown"`
+	ModifiedBy     string `db:"modified_by"`
+	ModifiedOn     int    `db:"modified_on"`
+	ETag           string `db:"etag"`
+	CreatedOn      int    `db:"created_on"`
+}
+
+func (db *sqlImpl) GetNotificationRevisions(notificationID string) (revisions []NotificationRevision, err error) {
+	err = db.sel(&revisions, fmt.Sprintf("SELECT %s FROM notification_revisions WHERE notification_id=? ORDER BY modified_on ASC", db.dialect.columns(NotificationRevision{})), notificationID)
+	return revisions, err
+}
+
+func (db *sqlImpl) GetNotificationRevision(notificationID string, version string) (revision NotificationRevision, err error) {
+	err = db.get(&revision, fmt.Sprintf("SELECT %s FROM notification_revisions WHERE notification_id=? AND version=?", db.dialect.columns(revision)), notificationID, version)
+	return revision, err
+}
+
+// GetExcessNotificationRevisions vrne različice, ki niso med keep najnovejšimi različicami svojega obvestila.
+func (db *sqlImpl) GetExcessNotificationRevisions(keep int) (revisions []NotificationRevision, err error) {
+	err = db.sel(&revisions, fmt.Sprintf(`SELECT %s FROM notification_revisions r
+WHERE (SELECT COUNT(*) FROM notification_revisions n WHERE n.notification_id=r.notification_id
+	AND (n.modified_on>r.modified_on OR (n.modified_on=r.modified_on AND n.version>r.version)))>=?
+ORDER BY notification_id ASC, modified_on ASC`, db.dialect.columns(NotificationRevision{})), keep)
+	return revisions, err
+}
+
+// InsertNotificationRevision doda različico, če je še nimamo, in vrne, ali jo je dodal.
+func (db *sqlImpl) InsertNotificationRevision(revision NotificationRevision) (inserted bool, err error) {
+	rows, err := db.namedExecRows(
+		`INSERT INTO notification_revisions
+	(notification_id,
+	 version,
+	 title,
+	 html,
+	 markdown,
+	 modified_by,
+	 modified_on,
+	 etag,
+	 created_on)
+VALUES (:notification_id,
+		:version,
+		:title,
+		:html,
+		:markdown,
+		:modified_by,
+		:modified_on,
+		:etag,
+		:created_on)
+ON CONFLICT (notification_id, version) DO NOTHING
+`, revision)
+	return rows > 0, err
+}
+
+func (db *sqlImpl) DeleteNotificationRevision(notificationID string, version string) error {
+	return db.exec(`DELETE FROM notification_revisions WHERE notification_id=? AND version=?`, notificationID, version)
+}
+
+func (db *sqlImpl) DeleteNotificationRevisions(notificationID string) error {
+	return db.exec(`DELETE FROM notification_revisions WHERE notification_id=?`, notificationID)
+}
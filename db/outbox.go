@@ -0,0 +1,126 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	OutboxActionPost   = "post"
+	OutboxActionEdit   = "edit"
+	OutboxActionDelete = "delete"
+	// OutboxActionRepost objavi posodobljeno obvestilo kot novo sporočilo.
+	OutboxActionRepost = "repost"
+	// OutboxActionDigest objavi pregled, NotificationID je v tem primeru ID pregleda.
+	OutboxActionDigest = "digest"
+	// OutboxActionDigestEdit posodobi objavljen pregled.
+	OutboxActionDigestEdit = "digest_edit"
+
+	OutboxStatusPending = "pending"
+	// OutboxStatusSending označuje dostavo, ki je bila poslana Discordu, a še ni potrjena.
+	OutboxStatusSending = "sending"
+	// OutboxStatusUncertain označuje objavo, za katero po sesutju ali dvoumni napaki ne vemo, ali je prispela.
+	OutboxStatusUncertain = "uncertain"
+	OutboxStatusDone      = "done"
+	OutboxStatusDead      = "dead"
+)
+
+type OutboxEntry struct {
+	ID             string `db:"id"`
+	NotificationID string `db:"notification_id"`
+	TargetID       string `db:"target_id"`
+	Action         string `db:"action"`
+	MessageID      string `db:"message_id"`
+	Status         string `db:"status"`
+	Attempts       int    `db:"attempts"`
+	NextAttemptOn  int    `db:"next_attempt_on"`
+	LastError      string `db:"last_error"`
+	CreatedOn      int    `db:"created_on"`
+	UpdatedOn      int    `db:"updated_on"`
+	// Summary je povzetek sprememb za ponovno objavo.
+	Summary string `db:"summary"`
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (db *sqlImpl) GetOutboxEntry(id string) (entry OutboxEntry, err error) {
+	err = db.get(&entry, fmt.Sprintf("SELECT %s FROM outbox WHERE id=?", db.dialect.columns(entry)), id)
+	return entry, err
+}
+
+func (db *sqlImpl) GetOutboxEntriesByStatus(status string) (entries []OutboxEntry, err error) {
+	err = db.sel(&entries, fmt.Sprintf("SELECT %s FROM outbox WHERE status=? ORDER BY created_on ASC", db.dialect.columns(OutboxEntry{})), status)
+	return entries, err
+}
+
+func (db *sqlImpl) GetOutboxEntriesForNotification(notificationID string) (entries []OutboxEntry, err error) {
+	err = db.sel(&entries, fmt.Sprintf("SELECT %s FROM outbox WHERE notification_id=? ORDER BY created_on ASC", db.dialect.columns(OutboxEntry{})), notificationID)
+	return entries, err
+}
+
+func (db *sqlImpl) GetOutboxEntriesForTarget(targetID string) (entries []OutboxEntry, err error) {
+	err = db.sel(&entries, fmt.Sprintf("SELECT %s FROM outbox WHERE target_id=? ORDER BY created_on ASC", db.dialect.columns(OutboxEntry{})), targetID)
+	return entries, err
+}
+
+func (db *sqlImpl) GetDueOutboxEntries(now int) (entries []OutboxEntry, err error) {
+	err = db.sel(&entries, fmt.Sprintf("SELECT %s FROM outbox WHERE status=? AND next_attempt_on<=%s ORDER BY next_attempt_on ASC, created_on ASC",
+		db.dialect.columns(OutboxEntry{}), db.dialect.ts("?")), OutboxStatusPending, now)
+	return entries, err
+}
+
+func (db *sqlImpl) InsertOutboxEntry(entry OutboxEntry) (err error) {
+	if entry.ID == "" {
+		entry.ID = newID()
+	}
+	err = db.namedExec(
+		`INSERT INTO outbox
+	(id,
+	 notification_id,
+	 target_id,
+	 action,
+	 message_id,
+	 status,
+	 attempts,
+	 next_attempt_on,
+	 last_error,
+	 created_on,
+	 updated_on,
+	 summary)
+VALUES (:id,
+		:notification_id,
+		:target_id,
+		:action,
+		:message_id,
+		:status,
+		:attempts,
+		:next_attempt_on,
+		:last_error,
+		:created_on,
+		:updated_on,
+		:summary)
+`, entry)
+	return err
+}
+
+func (db *sqlImpl) UpdateOutboxEntry(entry OutboxEntry) error {
+	return db.namedExec(
+		`UPDATE outbox SET
+			message_id=:message_id,
+			status=:status,
+			attempts=:attempts,
+			next_attempt_on=:next_attempt_on,
+			last_error=:last_error,
+			updated_on=:updated_on
+WHERE id=:id`,
+		entry)
+}
+
+func (db *sqlImpl) DeleteOutboxEntriesForNotification(notificationID string) error {
+	return db.exec(`DELETE FROM outbox WHERE notification_id=?`, notificationID)
+}
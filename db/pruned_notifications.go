@@ -0,0 +1,35 @@
+package db
+
+import "fmt"
+
+// PrunedNotification je sled obvestila, ki smo ga izbrisali po pravilih hranjenja, a je lahko še vedno
+// na SharePointu. Po njej ob preverjanju preskočimo element, dokler se na SharePointu ne spremeni.
+type PrunedNotification struct {
+	ID         string `db:"id"`
+	ModifiedOn int    `db:"modified_on"`
+	CreatedOn  int    `db:"created_on"`
+}
+
+func (db *sqlImpl) GetPrunedNotification(id string) (pruned PrunedNotification, err error) {
+	err = db.get(&pruned, fmt.Sprintf("SELECT %s FROM pruned_notifications WHERE id=?", db.dialect.columns(pruned)), id)
+	return pruned, err
+}
+
+func (db *sqlImpl) UpsertPrunedNotification(pruned PrunedNotification) (err error) {
+	err = db.namedExec(
+		`INSERT INTO pruned_notifications
+	(id,
+	 modified_on,
+	 created_on)
+VALUES (:id,
+		:modified_on,
+		:created_on)
+ON CONFLICT (id) DO UPDATE SET
+	modified_on=excluded.modified_on
+`, pruned)
+	return err
+}
+
+func (db *sqlImpl) DeletePrunedNotification(id string) error {
+	return db.exec(`DELETE FROM pruned_notifications WHERE id=?`, id)
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+type SearchResult struct {
+	SharepointNotification
+	// Rank je ocena ujemanja, večja je boljša.
+	Rank float64 `db:"rank"`
+	// Snippet je del besedila okoli zadetkov, ki so označeni z **.
+	Snippet string `db:"snippet"`
+}
+
+// ftsQuery iz uporabnikovega vnosa sestavi poizvedbo FTS5, v kateri morajo nastopati vse besede.
+// Besede so v narekovajih, da ločila (npr. "2.a") ne povzročijo napake v sintaksi FTS5.
+func ftsQuery(query string) string {
+	words := make([]string, 0)
+	for _, word := range strings.Fields(query) {
+		words = append(words, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	return strings.Join(words, " ")
+}
+
+// SearchSharepointNotifications poišče obvestila po naslovu in besedilu, najboljši zadetki so prvi.
+// Iskanje ne loči velikih in malih črk ter strešic (šola najde tudi sola).
+func (db *sqlImpl) SearchSharepointNotifications(query string, limit int) (results []SearchResult, err error) {
+	if strings.TrimSpace(query) == "" {
+		return make([]SearchResult, 0), nil
+	}
+
+	args := []any{}
+	var search string
+	if db.dialect == dialectPostgres {
+		search = `SELECT n.*, ts_rank(n.search, q) AS rank,
+	ts_headline('simple', n.description, q, 'StartSel=**, StopSel=**, MaxWords=20, MinWords=8') AS snippet
+FROM sharepoint_notifications n, plainto_tsquery('simple', translate(lower(?), 'čćšžđ', 'ccszd')) q
+WHERE n.search @@ q`
+		args = append(args, strings.TrimSpace(query))
+	} else if fts, err := db.hasSearchIndex(); err != nil {
+		return nil, err
+	} else if fts {
+		search = `SELECT n.*, -f.rank AS rank, snippet(sharepoint_notifications_fts, 2, '**', '**', '…', 16) AS snippet
+FROM sharepoint_notifications_fts f JOIN sharepoint_notifications n ON n.id=f.id
+WHERE sharepoint_notifications_fts MATCH ?`
+		args = append(args, ftsQuery(query))
+	} else {
+		// brez FTS5 vse besede iščemo z LIKE, ki ne loči velikih in malih črk le pri ASCII in ne razvršča
+		conditions := make([]string, 0)
+		for _, word := range strings.Fields(query) {
+			pattern := "%" + likeEscaper.Replace(word) + "%"
+			conditions = append(conditions, `(n.name LIKE ? ESCAPE '\' OR n.description LIKE ? ESCAPE '\')`)
+			args = append(args, pattern, pattern)
+		}
+		search = `SELECT n.*, 0 AS rank, substr(n.description, 1, 160) AS snippet FROM sharepoint_notifications n WHERE ` + strings.Join(conditions, " AND ")
+	}
+
+	err = db.sel(&results, fmt.Sprintf("SELECT %s, rank, snippet FROM (%s) AS results ORDER BY rank DESC, modified_on DESC LIMIT ?",
+		db.dialect.columns(SharepointNotification{}), search), append(args, limit)...)
+	return results, err
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// hasSearchIndex vrne true, če je SQLite zgrajen s FTS5 in je indeks FTS5 vzpostavljen.
+func (db *sqlImpl) hasSearchIndex() (bool, error) {
+	var fts bool
+	err := db.get(&fts, `SELECT sqlite_compileoption_used('ENABLE_FTS5') AND EXISTS (SELECT 1 FROM sqlite_master WHERE type='trigger' AND name='sharepoint_notifications_fts_insert')`)
+	return fts, err
+}
@@ -1,5 +1,7 @@
 package db
 
+import "fmt"
+
 type SharepointNotification struct {
 	ID             string `db:"id"`
 	Name           string `db:"name"`
@@ -8,23 +10,53 @@ type SharepointNotification struct {
 	ModifiedOn     int    `db:"modified_on"`
 	CreatedBy      string `db:"created_by"`
 	ModifiedBy     string `db:"modified_by"`
-	MessageIDs     string `db:"message_ids"`
 	ExpiresOn      int    `db:"expires_on"`
 	HasAttachments bool   `db:"has_attachments"`
+	ListID         string `db:"list_id"`
+	ContentType    string `db:"content_type"`
+	// Fields so vsi stolpci elementa kot JSON, vključno s stolpci po meri.
+	Fields string `db:"fields"`
+	WebURL string `db:"web_url"`
+	// različica pred zadnjo spremembo naslova, besedila ali veljavnosti
+	PreviousName        string `db:"previous_name"`
+	PreviousDescription string `db:"previous_description"`
+	PreviousExpiresOn   int    `db:"previous_expires_on"`
+	// UpdatedOn je čas, ko smo spremembo opazili ob preverjanju, za razliko od ModifiedOn s SharePointa.
+	UpdatedOn int `db:"updated_on"`
 }
 
 func (db *sqlImpl) GetSharepointNotification(id string) (notification SharepointNotification, err error) {
-	err = db.db.Get(&notification, "SELECT * FROM sharepoint_notifications WHERE id=$1", id)
+	err = db.get(&notification, fmt.Sprintf("SELECT %s FROM sharepoint_notifications WHERE id=?", db.dialect.columns(notification)), id)
 	return notification, err
 }
 
 func (db *sqlImpl) GetSharepointNotifications() (notification []SharepointNotification, err error) {
-	err = db.db.Select(&notification, "SELECT * FROM sharepoint_notifications ORDER BY modified_on ASC")
+	err = db.sel(&notification, fmt.Sprintf("SELECT %s FROM sharepoint_notifications ORDER BY modified_on ASC", db.dialect.columns(SharepointNotification{})))
+	return notification, err
+}
+
+func (db *sqlImpl) GetActiveSharepointNotifications(now int) (notification []SharepointNotification, err error) {
+	err = db.sel(&notification, fmt.Sprintf("SELECT %s FROM sharepoint_notifications WHERE expires_on=%s OR expires_on>%s ORDER BY created_on ASC",
+		db.dialect.columns(SharepointNotification{}), db.dialect.ts("?"), db.dialect.ts("?")), 0, now)
+	return notification, err
+}
+
+// GetSharepointNotificationsUpdatedBetween vrne obvestila, ki smo jih ustvarili ali posodobili med from in to.
+func (db *sqlImpl) GetSharepointNotificationsUpdatedBetween(from int, to int) (notification []SharepointNotification, err error) {
+	err = db.sel(&notification, fmt.Sprintf("SELECT %s FROM sharepoint_notifications WHERE updated_on>%s AND updated_on<=%s ORDER BY created_on ASC",
+		db.dialect.columns(SharepointNotification{}), db.dialect.ts("?"), db.dialect.ts("?")), from, to)
+	return notification, err
+}
+
+// GetExpiredSharepointNotifications vrne obvestila, ki so potekla pred before.
+func (db *sqlImpl) GetExpiredSharepointNotifications(before int) (notification []SharepointNotification, err error) {
+	err = db.sel(&notification, fmt.Sprintf("SELECT %s FROM sharepoint_notifications WHERE expires_on>%s AND expires_on<%s ORDER BY expires_on ASC",
+		db.dialect.columns(SharepointNotification{}), db.dialect.ts("?"), db.dialect.ts("?")), 0, before)
 	return notification, err
 }
 
 func (db *sqlImpl) InsertSharepointNotification(notification SharepointNotification) (err error) {
-	_, err = db.db.NamedExec(
+	err = db.namedExec(
 		`INSERT INTO sharepoint_notifications
 	(id,
 	 name,
@@ -33,9 +65,16 @@ func (db *sqlImpl) InsertSharepointNotification(notification SharepointNotificat
 	 modified_on,
 	 created_by,
 	 modified_by,
-	 message_ids,
 	 expires_on,
-	 has_attachments) 
+	 has_attachments,
+	 list_id,
+	 content_type,
+	 fields,
+	 web_url,
+	 previous_name,
+	 previous_description,
+	 previous_expires_on,
+	 updated_on)
 VALUES (:id,
 		:name,
 		:description,
@@ -43,29 +82,40 @@ VALUES (:id,
 		:modified_on,
 		:created_by,
 		:modified_by,
-		:message_ids,
 		:expires_on,
-		:has_attachments)
+		:has_attachments,
+		:list_id,
+		:content_type,
+		:fields,
+		:web_url,
+		:previous_name,
+		:previous_description,
+		:previous_expires_on,
+		:updated_on)
 `, notification)
 	return err
 }
 
 func (db *sqlImpl) UpdateSharepointNotification(notification SharepointNotification) error {
-	_, err := db.db.NamedExec(
+	return db.namedExec(
 		`UPDATE sharepoint_notifications SET
 			name=:name,
 			description=:description,
 			modified_on=:modified_on,
 			modified_by=:modified_by,
-			message_ids=:message_ids,
 			expires_on=:expires_on,
-			has_attachments=:has_attachments
+			has_attachments=:has_attachments,
+			content_type=:content_type,
+			fields=:fields,
+			web_url=:web_url,
+			previous_name=:previous_name,
+			previous_description=:previous_description,
+			previous_expires_on=:previous_expires_on,
+			updated_on=:updated_on
 WHERE id=:id`,
 		notification)
-	return err
 }
 
 func (db *sqlImpl) DeleteSharepointNotification(id string) error {
-	_, err := db.db.Exec(`DELETE FROM sharepoint_notifications WHERE id=$1`, id)
-	return err
+	return db.exec(`DELETE FROM sharepoint_notifications WHERE id=?`, id)
 }
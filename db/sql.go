@@ -1,35 +1,148 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
+	"time"
 )
 
+// QueryTimeout omeji trajanje poizvedbe, če kontekst nima svojega roka.
+var QueryTimeout = 30 * time.Second
+
+// executor je skupni del sqlx.DB in sqlx.Tx, da metode delujejo tudi znotraj transakcije.
+type executor interface {
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	NamedExecContext(ctx context.Context, query string, arg any) (sql.Result, error)
+	Rebind(query string) string
+}
+
 type sqlImpl struct {
-	db     *sqlx.DB
-	logger *zap.SugaredLogger
+	db      *sqlx.DB
+	ex      executor
+	tx      *sqlx.Tx
+	ctx     context.Context
+	dialect dialect
+	logger  *zap.SugaredLogger
+}
+
+func (db *sqlImpl) Ping() error {
+	ctx, cancel := db.context()
+	defer cancel()
+	return db.db.PingContext(ctx)
+}
+
+// WithContext vrne SQL, katerega poizvedbe uporabljajo podani kontekst.
+func (db *sqlImpl) WithContext(ctx context.Context) SQL {
+	c := *db
+	c.ctx = ctx
+	return &c
+}
+
+// WithTx izvede f v transakciji, ki se potrdi, če f ne vrne napake, sicer se razveljavi.
+// Znotraj obstoječe transakcije se f izvede kar v njej.
+func (db *sqlImpl) WithTx(ctx context.Context, f func(tx SQL) error) (err error) {
+	if db.tx != nil {
+		return f(db.WithContext(ctx))
+	}
+
+	tx, err := db.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	c := *db
+	c.ex = tx
+	c.tx = tx
+	c.ctx = ctx
+	err = f(&c)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			db.logger.Errorw("error rolling back transaction", "err", rollbackErr)
+		}
+		return err
+	}
+	return tx.Commit()
 }
 
 func (db *sqlImpl) Init() {
-	db.db.MustExec(schema)
+	err := db.Migrate()
+	if err != nil {
+		db.logger.Fatalw("error migrating database", "err", err)
+	}
 }
 
 type SQL interface {
 	Init()
+	WithContext(ctx context.Context) SQL
+	WithTx(ctx context.Context, f func(tx SQL) error) error
+	Ping() error
+	Migrate() error
+	MigrationStatus() ([]MigrationStatus, error)
 
 	GetSharepointNotification(id string) (notification SharepointNotification, err error)
 	GetSharepointNotifications() (notification []SharepointNotification, err error)
+	GetActiveSharepointNotifications(now int) (notification []SharepointNotification, err error)
+	GetSharepointNotificationsUpdatedBetween(from int, to int) (notification []SharepointNotification, err error)
+	GetExpiredSharepointNotifications(before int) (notification []SharepointNotification, err error)
 	InsertSharepointNotification(notification SharepointNotification) (err error)
 	UpdateSharepointNotification(notification SharepointNotification) error
 	DeleteSharepointNotification(id string) error
+	SearchSharepointNotifications(query string, limit int) (results []SearchResult, err error)
+
+	GetOutboxEntry(id string) (entry OutboxEntry, err error)
+	GetOutboxEntriesByStatus(status string) (entries []OutboxEntry, err error)
+	GetOutboxEntriesForNotification(notificationID string) (entries []OutboxEntry, err error)
+	GetOutboxEntriesForTarget(targetID string) (entries []OutboxEntry, err error)
+	GetDueOutboxEntries(now int) (entries []OutboxEntry, err error)
+	InsertOutboxEntry(entry OutboxEntry) (err error)
+	UpdateOutboxEntry(entry OutboxEntry) error
+	DeleteOutboxEntriesForNotification(notificationID string) error
+
+	GetDelivery(notificationID string, targetID string) (delivery Delivery, err error)
+	GetDeliveriesForNotification(notificationID string) (deliveries []Delivery, err error)
+	GetDeliveriesForTarget(targetID string) (deliveries []Delivery, err error)
+	GetDeliveryTargetIDs() (targetIDs []string, err error)
+	UpdateDeliveriesStatusForTarget(targetID string, from string, to string) error
+	UpsertDelivery(delivery Delivery) (err error)
+	InsertDelivery(delivery Delivery) (err error)
+	DeleteDelivery(notificationID string, targetID string) error
+
+	GetNotificationRevisions(notificationID string) (revisions []NotificationRevision, err error)
+	GetNotificationRevision(notificationID string, version string) (revision NotificationRevision, err error)
+	GetExcessNotificationRevisions(keep int) (revisions []NotificationRevision, err error)
+	InsertNotificationRevision(revision NotificationRevision) (inserted bool, err error)
+	DeleteNotificationRevision(notificationID string, version string) error
+	DeleteNotificationRevisions(notificationID string) error
+
+	GetPrunedNotification(id string) (pruned PrunedNotification, err error)
+	UpsertPrunedNotification(pruned PrunedNotification) (err error)
+	DeletePrunedNotification(id string) error
+
+	GetDigest(id string) (digest Digest, err error)
+	GetLatestDigest(targetID string) (digest Digest, err error)
+	InsertDigest(digest Digest) (err error)
+	UpdateDigest(digest Digest) error
 }
 
 func NewSQL(driver string, drivername string, logger *zap.SugaredLogger) (SQL, error) {
 	db, err := sqlx.Connect(driver, drivername)
 	return &sqlImpl{
-		db:     db,
-		logger: logger,
+		db:      db,
+		ex:      db,
+		ctx:     context.Background(),
+		dialect: newDialect(driver),
+		logger:  logger,
 	}, err
 }
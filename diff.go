@@ -0,0 +1,257 @@
+package main
+
+import (
+	"SharepointBot/db"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DiffEqual  = 0
+	DiffInsert = 1
+	DiffDelete = 2
+)
+
+type DiffOp struct {
+	Kind  int
+	Words []string
+}
+
+// MaxDiffCells omeji velikost tabele LCS. Večji spremenjeni del prikažemo kot zamenjavo v celoti.
+var MaxDiffCells = 1 << 20
+
+// WordDiff izračuna razliko med besedili po besedah (najdaljše skupno podzaporedje).
+func WordDiff(old string, new string) []DiffOp {
+	a := strings.Fields(old)
+	b := strings.Fields(new)
+
+	ops := make([]DiffOp, 0)
+	add := func(kind int, word string) {
+		if len(ops) != 0 && ops[len(ops)-1].Kind == kind {
+			ops[len(ops)-1].Words = append(ops[len(ops)-1].Words, word)
+			return
+		}
+		ops = append(ops, DiffOp{Kind: kind, Words: []string{word}})
+	}
+
+	// skupni začetek in konec ne potrebujeta tabele, ob urejanju je to večina besedila
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		add(DiffEqual, a[prefix])
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	tail := a[len(a)-suffix:]
+	a = a[prefix : len(a)-suffix]
+	b = b[prefix : len(b)-suffix]
+
+	if (len(a)+1)*(len(b)+1) > MaxDiffCells {
+		for _, word := range a {
+			add(DiffDelete, word)
+		}
+		for _, word := range b {
+			add(DiffInsert, word)
+		}
+	} else {
+		diffLCS(a, b, add)
+	}
+
+	for _, word := range tail {
+		add(DiffEqual, word)
+	}
+	return ops
+}
+
+func diffLCS(a []string, b []string, add func(kind int, word string)) {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add(DiffEqual, a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add(DiffDelete, a[i])
+			i++
+		default:
+			add(DiffInsert, b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add(DiffDelete, a[i])
+	}
+	for ; j < len(b); j++ {
+		add(DiffInsert, b[j])
+	}
+}
+
+// DiffStats vrne število dodanih, odstranjenih in nespremenjenih besed.
+func DiffStats(ops []DiffOp) (added int, removed int, equal int) {
+	for _, op := range ops {
+		switch op.Kind {
+		case DiffInsert:
+			added += len(op.Words)
+		case DiffDelete:
+			removed += len(op.Words)
+		default:
+			equal += len(op.Words)
+		}
+	}
+	return added, removed, equal
+}
+
+// SubstantialChange je delež spremenjenih besed, nad katerim spremembo štejemo za bistveno.
+var SubstantialChange = 0.2
+
+// IsSubstantialChange vrne true, če se je spremenil naslov ali bistven del besedila.
+func IsSubstantialChange(oldTitle string, oldBody string, newTitle string, newBody string) bool {
+	if strings.TrimSpace(oldTitle) != strings.TrimSpace(newTitle) {
+		return true
+	}
+	added, removed, equal := DiffStats(WordDiff(oldBody, newBody))
+	total := added + removed + equal
+	if total == 0 {
+		return false
+	}
+	return float64(added+removed)/float64(total) > SubstantialChange
+}
+
+// DiffSummary povzame spremembo za objavo posodobljenega obvestila.
+func DiffSummary(oldTitle string, oldBody string, newTitle string, newBody string) string {
+	parts := make([]string, 0)
+	if strings.TrimSpace(oldTitle) != strings.TrimSpace(newTitle) {
+		parts = append(parts, fmt.Sprintf("Naslov spremenjen iz »%s«.", oldTitle))
+	}
+	added, removed, _ := DiffStats(WordDiff(oldBody, newBody))
+	if added != 0 || removed != 0 {
+		parts = append(parts, fmt.Sprintf("Besedilo: %d dodanih in %d odstranjenih besed.", added, removed))
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatExpiry(expires int) string {
+	if expires == 0 {
+		return "brez"
+	}
+	return time.Unix(int64(expires), 0).Format("02. 01. 2006")
+}
+
+// Version je vsebina obvestila, kot so jo videli bralci sporočila.
+type Version struct {
+	Name        string
+	Description string
+	ExpiresOn   int
+}
+
+// PostedVersion vrne različico, ki je bila objavljena v sporočilu dostave.
+func PostedVersion(delivery db.Delivery) Version {
+	return Version{delivery.PostedName, delivery.PostedDescription, delivery.PostedExpiresOn}
+}
+
+func CurrentVersion(notification db.SharepointNotification) Version {
+	return Version{notification.Name, notification.Description, notification.ExpiresOn}
+}
+
+// changesCache hrani izrisane spremembe po parih različic, da jih ob ponovnih poskusih in
+// objavah na več ciljev ne računamo znova.
+var changesCache = struct {
+	sync.Mutex
+	entries map[[32]byte]string
+}{entries: make(map[[32]byte]string)}
+
+const changesCacheSize = 256
+
+// RenderChanges vrne besedilo polja "Spremembe" z dodanimi (krepko) in odstranjenimi
+// (prečrtano) besedami glede na objavljeno različico obvestila.
+func RenderChanges(posted Version, current Version) string {
+	if posted.Name == "" && posted.Description == "" {
+		return ""
+	}
+
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%s\x00%d", posted.Name, posted.Description, posted.ExpiresOn, current.Name, current.Description, current.ExpiresOn)))
+	changesCache.Lock()
+	changes, ok := changesCache.entries[key]
+	changesCache.Unlock()
+	if ok {
+		return changes
+	}
+
+	changes = renderChanges(posted, current)
+	changesCache.Lock()
+	if len(changesCache.entries) >= changesCacheSize {
+		clear(changesCache.entries)
+	}
+	changesCache.entries[key] = changes
+	changesCache.Unlock()
+	return changes
+}
+
+func renderChanges(posted Version, current Version) string {
+	lines := make([]string, 0)
+	if posted.Name != current.Name {
+		lines = append(lines, fmt.Sprintf("Naslov: ~~%s~~ → **%s**", posted.Name, current.Name))
+	}
+	if posted.ExpiresOn != current.ExpiresOn {
+		lines = append(lines, fmt.Sprintf("Velja do: ~~%s~~ → **%s**", formatExpiry(posted.ExpiresOn), formatExpiry(current.ExpiresOn)))
+	}
+
+	ops := WordDiff(posted.Description, current.Description)
+	added, removed, _ := DiffStats(ops)
+	if added != 0 || removed != 0 {
+		words := make([]string, 0)
+		for i, op := range ops {
+			switch op.Kind {
+			case DiffInsert:
+				words = append(words, fmt.Sprintf("**%s**", strings.Join(op.Words, " ")))
+			case DiffDelete:
+				words = append(words, fmt.Sprintf("~~%s~~", strings.Join(op.Words, " ")))
+			default:
+				// iz nespremenjenih delov ohranimo le nekaj besed okoli sprememb
+				context := op.Words
+				if len(context) > 6 {
+					head := context[:3]
+					tail := context[len(context)-3:]
+					switch {
+					case i == 0:
+						context = append([]string{"…"}, tail...)
+					case i == len(ops)-1:
+						context = append(slices.Clone(head), "…")
+					default:
+						context = append(append(slices.Clone(head), "…"), tail...)
+					}
+				}
+				words = append(words, strings.Join(context, " "))
+			}
+		}
+		lines = append(lines, strings.Join(words, " "))
+	}
+
+	changes := strings.Join(lines, "\n")
+	// omejitev Discorda za vrednost polja
+	if len([]rune(changes)) > 1024 {
+		changes = string([]rune(changes)[0:1021]) + "..."
+	}
+	return changes
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"SharepointBot/db"
+	"SharepointBot/discord"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"slices"
+	"time"
+)
+
+var DigestPollInterval = time.Minute
+
+// DigestID vrne ID pregleda za cilj in obdobje, zato se isto obdobje ne more zapisati dvakrat.
+func DigestID(targetID string, periodEnd int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", targetID, periodEnd)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// lastDigestTime vrne zadnji trenutek ob DigestAt, ki je že minil.
+func lastDigestTime(target config.Target, location *time.Location, now time.Time) (time.Time, error) {
+	at, err := parseClock(target.DigestAt)
+	if err != nil {
+		return now, err
+	}
+	local := now.In(location)
+	last := at.on(local.Year(), local.Month(), local.Day(), location)
+	if last.After(local) {
+		last = at.on(local.Year(), local.Month(), local.Day()-1, location)
+	}
+	return last, nil
+}
+
+func RenderDigest(digest db.Digest, notifications []db.SharepointNotification, location *time.Location) discord.WebhookBody {
+	lines := make([]string, 0)
+	for _, notification := range notifications {
+		state := "posodobljeno"
+		if notification.CreatedOn > digest.PeriodStart {
+			state = "novo"
+		}
+		lines = append(lines, fmt.Sprintf("• [%s](%s) — *%s*", notification.Name, NotificationURL(notification), state))
+	}
+
+	description := ""
+	for i, line := range lines {
+		if len([]rune(description))+len([]rune(line))+1 > 4000 {
+			description += fmt.Sprintf("\n... in še %d obvestil", len(lines)-i)
+			break
+		}
+		if description != "" {
+			description += "\n"
+		}
+		description += line
+	}
+
+	periodStart := time.Unix(int64(digest.PeriodStart), 0).In(location).Format("02. 01. 2006 ob 15.04")
+	periodEnd := time.Unix(int64(digest.PeriodEnd), 0).In(location).Format("02. 01. 2006 ob 15.04")
+
+	return discord.WebhookBody{
+		Username: "Intranet",
+		Content:  "Pregled obvestil na intranetu",
+		Embeds: []discord.Embed{
+			{
+				Title:       "Nova in spremenjena obvestila",
+				Description: description,
+				Color:       15258703,
+				Footer:      discord.EmbedFooter{Text: fmt.Sprintf("Od %s do %s", periodStart, periodEnd)},
+				Thumbnail:   discord.EmbedThumbnail{URL: "https://www.gimb.org/wp-content/uploads/2017/01/logo.png"},
+			},
+		},
+		AllowedMentions: &discord.AllowedMentions{Parse: make([]string, 0)},
+	}
+}
+
+func (server *httpImpl) routedToTarget(notification db.SharepointNotification, target config.Target) bool {
+	return slices.ContainsFunc(server.RouteNotification(notification), func(t config.Target) bool { return t.ID == target.ID })
+}
+
+// ProcessDigest ob DigestAt objavi pregled obvestil od zadnjega pregleda, sicer pa posodobi zadnji pregled,
+// če so se obvestila v njem medtem spremenila.
+func (server *httpImpl) ProcessDigest(target config.Target) error {
+	location := server.config.GetLocation()
+	now := time.Now()
+	due, err := lastDigestTime(target, location, now)
+	if err != nil {
+		return err
+	}
+
+	latest, err := server.db.GetLatestDigest(target.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	exists := err == nil
+
+	if !exists || latest.PeriodEnd < int(due.Unix()) {
+		periodStart := int(due.AddDate(0, 0, -1).Unix())
+		if exists {
+			periodStart = latest.PeriodEnd
+		}
+		digest := db.Digest{
+			ID:          DigestID(target.ID, int(due.Unix())),
+			TargetID:    target.ID,
+			PeriodStart: periodStart,
+			PeriodEnd:   int(due.Unix()),
+			CreatedOn:   int(now.Unix()),
+			UpdatedOn:   int(now.Unix()),
+		}
+
+		candidates, err := server.db.GetSharepointNotificationsUpdatedBetween(digest.PeriodStart, digest.PeriodEnd)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, 0)
+		for _, notification := range candidates {
+			if server.routedToTarget(notification, target) {
+				ids = append(ids, notification.ID)
+			}
+		}
+		marshal, err := json.Marshal(ids)
+		if err != nil {
+			return err
+		}
+		digest.NotificationIDs = string(marshal)
+
+		// pregled in njegova objava se zapišeta skupaj, objavi ga outbox
+		err = server.db.WithTx(context.Background(), func(tx db.SQL) error {
+			err := tx.InsertDigest(digest)
+			if err != nil {
+				return err
+			}
+			// brez obvestil ne objavimo ničesar, obdobje pa vseeno zabeležimo
+			if len(ids) == 0 {
+				return nil
+			}
+			return tx.InsertOutboxEntry(NewOutboxEntry(digest.ID, target.ID, db.OutboxActionDigest, "", now))
+		})
+		if err != nil {
+			return err
+		}
+		server.logger.Infow("enqueued digest", "target", target.ID, "digest", digest.ID, "notifications", len(ids))
+		return nil
+	}
+
+	// pregled, ki še ni objavljen, čaka v outboxu
+	if latest.MessageID == "" {
+		return nil
+	}
+
+	notifications, err := server.digestNotifications(context.Background(), latest)
+	if err != nil {
+		return err
+	}
+
+	body := RenderDigest(latest, notifications, location)
+	hash := RenderedHash(body)
+	if hash == latest.RenderedHash {
+		return nil
+	}
+	// urejanje izvede outbox, zgoščeno vrednost zapišemo že zdaj, da ga ne dodamo ob vsakem preverjanju
+	latest.RenderedHash = hash
+	latest.UpdatedOn = int(now.Unix())
+	err = server.db.WithTx(context.Background(), func(tx db.SQL) error {
+		err := tx.UpdateDigest(latest)
+		if err != nil {
+			return err
+		}
+		return tx.InsertOutboxEntry(NewOutboxEntry(latest.ID, target.ID, db.OutboxActionDigestEdit, latest.MessageID, now))
+	})
+	if err != nil {
+		return err
+	}
+	server.logger.Infow("enqueued digest edit", "target", target.ID, "digest", latest.ID, "message", latest.MessageID)
+	return nil
+}
+
+// digestNotifications vrne obvestila v pregledu, ki še obstajajo.
+func (server *httpImpl) digestNotifications(ctx context.Context, digest db.Digest) ([]db.SharepointNotification, error) {
+	var ids []string
+	err := json.Unmarshal([]byte(digest.NotificationIDs), &ids)
+	if err != nil {
+		return nil, err
+	}
+	notifications := make([]db.SharepointNotification, 0)
+	for _, id := range ids {
+		notification, err := server.db.WithContext(ctx).GetSharepointNotification(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+// deliverDigest objavi ali uredi pregled iz outboxa.
+func (server *httpImpl) deliverDigest(ctx context.Context, target config.Target, entry db.OutboxEntry) (db.Delivery, error) {
+	delivery := db.Delivery{
+		NotificationID: entry.NotificationID,
+		TargetID:       entry.TargetID,
+		MessageID:      entry.MessageID,
+		Status:         db.DeliveryStatusPosted,
+	}
+
+	digest, err := server.db.WithContext(ctx).GetDigest(entry.NotificationID)
+	if err != nil {
+		return delivery, err
+	}
+	notifications, err := server.digestNotifications(ctx, digest)
+	if err != nil {
+		return delivery, err
+	}
+	body := RenderDigest(digest, notifications, server.config.GetLocation())
+	delivery.RenderedHash = RenderedHash(body)
+
+	if entry.Action == db.OutboxActionDigestEdit {
+		editCtx, span := tracer.Start(ctx, "discord.EditWebhookMessage", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(AttrTargetID.String(target.ID), AttrMessageID.String(entry.MessageID), attribute.Int("notifications", len(notifications))))
+		_, err = server.discord.EditWebhookMessage(editCtx, target.Webhook, entry.MessageID, body)
+		endSpan(span, err)
+		if !errors.Is(err, discord.ErrUnknownMessage) {
+			return delivery, err
+		}
+		// moderator je pregled izbrisal
+		if target.OnDeleted != config.OnDeletedRepost {
+			server.logger.Infow("digest was deleted on Discord, no longer updating it", "digest", digest.ID, "target", target.ID, "message", entry.MessageID)
+			delivery.MessageID = ""
+			delivery.Status = db.DeliveryStatusRemoved
+			return delivery, nil
+		}
+		server.logger.Infow("digest was deleted on Discord, reposting", "digest", digest.ID, "target", target.ID, "message", entry.MessageID)
+	}
+
+	ctx, span := tracer.Start(ctx, "discord.ExecuteWebhook", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(AttrTargetID.String(target.ID), attribute.Int("notifications", len(notifications))))
+	message, err := server.discord.ExecuteWebhook(ctx, target.Webhook, body)
+	endSpan(span, err)
+	if err != nil {
+		return delivery, uncertainPost(err)
+	}
+	delivery.MessageID = message.ID
+	return delivery, nil
+}
+
+// recordDigestDelivery shrani objavljeno sporočilo k pregledu.
+func recordDigestDelivery(database db.SQL, delivery db.Delivery) error {
+	digest, err := database.GetDigest(delivery.NotificationID)
+	if err != nil {
+		return err
+	}
+	digest.MessageID = delivery.MessageID
+	digest.RenderedHash = delivery.RenderedHash
+	digest.UpdatedOn = int(time.Now().Unix())
+	return database.UpdateDigest(digest)
+}
+
+func (server *httpImpl) DigestGoroutine(ctx context.Context) error {
+	server.logger.Infow("starting digest goroutine")
+
+	for {
+		for _, target := range server.config.GetTargets() {
+			if !target.IsDigest() || ctx.Err() != nil {
+				continue
+			}
+			err := server.ProcessDigest(target)
+			if err != nil {
+				server.logger.Errorw("error processing digest", "target", target.ID, "err", err)
+			}
+		}
+		if !sleep(ctx, DigestPollInterval) {
+			server.logger.Infow("exiting digest goroutine")
+			return nil
+		}
+	}
+}
+
+// MessageTargets vrne cilje, ki za obvestilo prejmejo samostojno sporočilo.
+func (server *httpImpl) MessageTargets(notification db.SharepointNotification) []config.Target {
+	targets := make([]config.Target, 0)
+	for _, target := range server.RouteNotification(notification) {
+		if target.IsDigest() {
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
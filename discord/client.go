@@ -0,0 +1,224 @@
+package discord
+
+import (
+	"SharepointBot/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/imroc/req/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var MaxRetries = 3
+
+type bucket struct {
+	remaining int
+	reset     time.Time
+}
+
+// Client je Discord webhook odjemalec, ki upošteva omejitve hitrosti po posameznih poteh.
+type Client struct {
+	client *req.Client
+	logger *zap.SugaredLogger
+
+	// OnRateLimit se pokliče, preden odjemalec čaka zaradi omejitve hitrosti.
+	OnRateLimit func(route string, wait time.Duration)
+
+	mu          sync.Mutex
+	routes      map[string]string
+	buckets     map[string]*bucket
+	globalReset time.Time
+}
+
+func NewClient(logger *zap.SugaredLogger, debug bool) *Client {
+	client := req.C()
+	if debug {
+		client.DevMode()
+	}
+	return &Client{
+		client:  client,
+		logger:  logger,
+		routes:  make(map[string]string),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// route vrne ključ poti, kot ga za omejitve hitrosti uporablja Discord (metoda + webhook + oblika poti).
+func route(method string, webhook string, messages bool) string {
+	id := config.WebhookID(webhook)
+	if messages {
+		return fmt.Sprintf("%s webhooks/%s/messages", method, id)
+	}
+	return fmt.Sprintf("%s webhooks/%s", method, id)
+}
+
+// sleep počaka d ali do preklica ctx, takrat vrne ctx.Err().
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) wait(ctx context.Context, route string) error {
+	c.mu.Lock()
+	until := c.globalReset
+	if hash, ok := c.routes[route]; ok {
+		if b, ok := c.buckets[hash]; ok && b.remaining <= 0 && b.reset.After(until) {
+			until = b.reset
+		}
+	}
+	c.mu.Unlock()
+
+	if d := time.Until(until); d > 0 {
+		c.logger.Infow("waiting for Discord rate limit", "route", route, "wait", d)
+		c.rateLimited(ctx, route, d)
+		return sleep(ctx, d)
+	}
+	return nil
+}
+
+func (c *Client) rateLimited(ctx context.Context, route string, d time.Duration) {
+	trace.SpanFromContext(ctx).AddEvent("rate limited", trace.WithAttributes(attribute.String("route", route), attribute.Float64("wait_seconds", d.Seconds())))
+	if c.OnRateLimit != nil {
+		c.OnRateLimit(route, d)
+	}
+}
+
+func (c *Client) update(route string, resp *req.Response) {
+	hash := resp.GetHeader("X-RateLimit-Bucket")
+	if hash == "" {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.GetHeader("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	resetAfter, err := strconv.ParseFloat(resp.GetHeader("X-RateLimit-Reset-After"), 64)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes[route] = hash
+	c.buckets[hash] = &bucket{
+		remaining: remaining,
+		reset:     time.Now().Add(time.Duration(resetAfter * float64(time.Second))),
+	}
+}
+
+func (c *Client) retryAfter(resp *req.Response) time.Duration {
+	var body struct {
+		RetryAfter float64 `json:"retry_after"`
+		Global     bool    `json:"global"`
+	}
+	_ = json.Unmarshal(resp.Bytes(), &body)
+
+	retryAfter := body.RetryAfter
+	if header, err := strconv.ParseFloat(resp.GetHeader("Retry-After"), 64); err == nil && header > retryAfter {
+		retryAfter = header
+	}
+	if retryAfter <= 0 {
+		retryAfter = 1
+	}
+	d := time.Duration(retryAfter * float64(time.Second))
+
+	if body.Global || resp.GetHeader("X-RateLimit-Global") == "true" {
+		c.mu.Lock()
+		c.globalReset = time.Now().Add(d)
+		c.mu.Unlock()
+	}
+	return d
+}
+
+func (c *Client) do(ctx context.Context, method string, url string, route string, body any, result any) error {
+	for attempt := 0; ; attempt++ {
+		err := c.wait(ctx, route)
+		if err != nil {
+			return err
+		}
+
+		// napake pred pošiljanjem ločimo od tistih, pri katerih je zahteva morda že prišla do Discorda
+		var wrote atomic.Bool
+		traced := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{WroteHeaders: func() { wrote.Store(true) }})
+
+		request := c.client.R().SetContext(traced)
+		if body != nil {
+			request.SetBodyJsonMarshal(body)
+		}
+		resp, err := request.Send(method, url)
+		if err == nil && (resp == nil || resp.Response == nil) {
+			err = errors.New("empty response")
+		}
+		if err != nil {
+			if wrote.Load() {
+				return fmt.Errorf("%w: failure while sending request to Discord: %w", ErrUncertain, err)
+			}
+			return fmt.Errorf("failure while sending request to Discord: %w", err)
+		}
+		c.update(route, resp)
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			d := c.retryAfter(resp)
+			if attempt >= MaxRetries {
+				return &Error{StatusCode: resp.StatusCode, Message: fmt.Sprintf("rate limited, retry after %s", d)}
+			}
+			c.logger.Warnw("rate limited by Discord", "route", route, "retryAfter", d)
+			c.rateLimited(ctx, route, d)
+			err = sleep(ctx, d)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			if result == nil || resp.StatusCode == http.StatusNoContent {
+				return nil
+			}
+			err = resp.UnmarshalJson(result)
+			if err != nil {
+				return fmt.Errorf("%w: error parsing Discord response: %w", ErrUncertain, err)
+			}
+			return nil
+		}
+
+		e := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(resp.Bytes(), e) != nil || e.Message == "" {
+			e.Message = resp.String()
+		}
+		e.Permanent = permanentStatus(resp.StatusCode)
+		return e
+	}
+}
+
+// ExecuteWebhook pošlje novo sporočilo in vrne ustvarjeno sporočilo.
+func (c *Client) ExecuteWebhook(ctx context.Context, webhook string, body WebhookBody) (Message, error) {
+	var message Message
+	err := c.do(ctx, http.MethodPost, webhook+"?wait=true", route(http.MethodPost, webhook, false), body, &message)
+	return message, err
+}
+
+func (c *Client) EditWebhookMessage(ctx context.Context, webhook string, messageID string, body WebhookBody) (Message, error) {
+	var message Message
+	err := c.do(ctx, http.MethodPatch, fmt.Sprintf("%s/messages/%s", webhook, messageID), route(http.MethodPatch, webhook, true), body, &message)
+	return message, err
+}
+
+func (c *Client) DeleteWebhookMessage(ctx context.Context, webhook string, messageID string) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("%s/messages/%s", webhook, messageID), route(http.MethodDelete, webhook, true), nil, nil)
+}
@@ -0,0 +1,197 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testServer vrne webhook na testnem strežniku, ki na zahteve odgovarja s handler, in števec zahtev.
+func testServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, call int)) (string, *atomic.Int32) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, int(calls.Add(1)))
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL + "/api/webhooks/123/token", &calls
+}
+
+func writeMessage(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"id":"456"}`))
+}
+
+func newTestClient() *Client {
+	return NewClient(zap.NewNop().Sugar(), false)
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	webhook, calls := testServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		if call == 1 {
+			w.Header().Set("Retry-After", "0.1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"message":"You are being rate limited.","retry_after":0.05,"global":false}`))
+			return
+		}
+		writeMessage(w)
+	})
+	client := newTestClient()
+	var waited time.Duration
+	client.OnRateLimit = func(route string, wait time.Duration) {
+		if route != "POST webhooks/123" {
+			t.Errorf("unexpected route %q", route)
+		}
+		waited = wait
+	}
+
+	start := time.Now()
+	message, err := client.ExecuteWebhook(context.Background(), webhook, WebhookBody{Content: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.ID != "456" || calls.Load() != 2 {
+		t.Errorf("got message %q after %d calls", message.ID, calls.Load())
+	}
+	// Retry-After v glavi je daljši od retry_after v telesu
+	if waited != 100*time.Millisecond || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("waited %s (reported %s), want 100ms", time.Since(start), waited)
+	}
+}
+
+func TestRateLimitBucket(t *testing.T) {
+	webhook, _ := testServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Header().Set("X-RateLimit-Bucket", "abc")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset-After", "0.1")
+		writeMessage(w)
+	})
+	client := newTestClient()
+	waits := 0
+	client.OnRateLimit = func(route string, wait time.Duration) { waits++ }
+
+	for i := 0; i < 2; i++ {
+		_, err := client.ExecuteWebhook(context.Background(), webhook, WebhookBody{Content: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if waits != 1 {
+		t.Errorf("waited %d times for an exhausted bucket, want 1", waits)
+	}
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	webhook, calls := testServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Header().Set("X-RateLimit-Global", "true")
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"message":"You are being rate limited.","retry_after":10,"global":true}`))
+	})
+	client := newTestClient()
+	retries := MaxRetries
+	MaxRetries = 0
+	defer func() { MaxRetries = retries }()
+
+	_, err := client.ExecuteWebhook(context.Background(), webhook, WebhookBody{Content: "test"})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("got %v, want ErrRateLimited", err)
+	}
+
+	// globalna omejitev velja tudi za druge poti, zato ta zahteva čaka, dokler je ne prekličemo
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.DeleteWebhookMessage(ctx, webhook, "456")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("got %d calls, want 1", calls.Load())
+	}
+}
+
+func TestRateLimitWaitCancelled(t *testing.T) {
+	webhook, calls := testServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	client := newTestClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.EditWebhookMessage(ctx, webhook, "456", WebhookBody{Content: "test"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if time.Since(start) > time.Second || calls.Load() != 1 {
+		t.Errorf("returned after %s and %d calls", time.Since(start), calls.Load())
+	}
+}
+
+func TestUnknownMessage(t *testing.T) {
+	webhook, _ := testServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"Unknown Message","code":10008}`))
+	})
+	client := newTestClient()
+
+	_, err := client.EditWebhookMessage(context.Background(), webhook, "456", WebhookBody{Content: "test"})
+	if !errors.Is(err, ErrUnknownMessage) || errors.Is(err, ErrUnknownWebhook) {
+		t.Errorf("got %v, want ErrUnknownMessage", err)
+	}
+	if !IsPermanent(err) {
+		t.Errorf("%v should be permanent", err)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	webhook, _ := testServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	client := newTestClient()
+
+	_, err := client.ExecuteWebhook(context.Background(), webhook, WebhookBody{Content: "test"})
+	if !errors.Is(err, ErrUncertain) {
+		t.Errorf("got %v, want ErrUncertain", err)
+	}
+	if IsPermanent(err) {
+		t.Errorf("%v should not be permanent", err)
+	}
+}
+
+func TestConnectionClosedAfterWrite(t *testing.T) {
+	webhook, _ := testServer(t, func(w http.ResponseWriter, r *http.Request, call int) {
+		// zahteva je prispela, odgovora pa ni
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_ = conn.Close()
+	})
+	client := newTestClient()
+
+	_, err := client.ExecuteWebhook(context.Background(), webhook, WebhookBody{Content: "test"})
+	if !errors.Is(err, ErrUncertain) {
+		t.Errorf("got %v, want ErrUncertain", err)
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	webhook := ts.URL + "/api/webhooks/123/token"
+	ts.Close()
+	client := newTestClient()
+
+	_, err := client.ExecuteWebhook(context.Background(), webhook, WebhookBody{Content: "test"})
+	if err == nil || errors.Is(err, ErrUncertain) {
+		t.Errorf("got %v, want a certain failure", err)
+	}
+}
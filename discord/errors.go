@@ -0,0 +1,72 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Discord JSON error codes, https://discord.com/developers/docs/topics/opcodes-and-status-codes#json
+const (
+	CodeUnknownWebhook = 10015
+	CodeUnknownMessage = 10008
+)
+
+var (
+	ErrUnknownWebhook = errors.New("unknown webhook")
+	ErrUnknownMessage = errors.New("unknown message")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrRateLimited    = errors.New("rate limited")
+	// ErrUncertain pomeni, da je zahteva morda prišla do Discorda (prekinjena povezava po pošiljanju, 5xx),
+	// zato ne vemo, ali jo je izvedel.
+	ErrUncertain = errors.New("request outcome is uncertain")
+)
+
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	// Permanent je true, kadar ponovni poskus ne bo pomagal.
+	Permanent bool `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("discord responded with status code %d (code %d): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("discord responded with status code %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnknownWebhook:
+		return e.Code == CodeUnknownWebhook
+	case ErrUnknownMessage:
+		return e.Code == CodeUnknownMessage
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUncertain:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// IsPermanent vrne true, če je napaka trajna (npr. izbrisan webhook ali sporočilo).
+// Omrežne napake in 5xx odgovori so začasni.
+func IsPermanent(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Permanent
+	}
+	return false
+}
+
+func permanentStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusRequestEntityTooLarge:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package discord
+
+import "time"
+
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type EmbedAuthor struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	IconURL string `json:"icon_url"`
+}
+
+type EmbedThumbnail struct {
+	URL string `json:"url"`
+}
+
+type EmbedImage struct {
+	URL string `json:"url"`
+}
+
+type EmbedFooter struct {
+	Text    string `json:"text"`
+	IconURL string `json:"icon_url"`
+}
+
+type Embed struct {
+	Author      EmbedAuthor    `json:"author"`
+	Title       string         `json:"title"`
+	URL         string         `json:"url"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []EmbedField   `json:"fields"`
+	Thumbnail   EmbedThumbnail `json:"thumbnail"`
+	Image       EmbedImage     `json:"image"`
+	Footer      EmbedFooter    `json:"footer"`
+}
+
+// AllowedMentions omeji, koga sporočilo dejansko pingne.
+// https://discord.com/developers/docs/resources/message#allowed-mentions-object
+type AllowedMentions struct {
+	Parse []string `json:"parse"`
+	Roles []string `json:"roles,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
+type WebhookBody struct {
+	Username        string           `json:"username"`
+	AvatarURL       string           `json:"avatar_url"`
+	Content         string           `json:"content"`
+	Embeds          []Embed          `json:"embeds"`
+	AllowedMentions *AllowedMentions `json:"allowed_mentions,omitempty"`
+}
+
+type Message struct {
+	Type         int    `json:"type"`
+	Content      string `json:"content"`
+	Mentions     []any  `json:"mentions"`
+	MentionRoles []any  `json:"mention_roles"`
+	Attachments  []any  `json:"attachments"`
+	Embeds       []struct {
+		Type   string `json:"type"`
+		URL    string `json:"url"`
+		Color  int    `json:"color"`
+		Fields []struct {
+			Name   string `json:"name"`
+			Value  string `json:"value"`
+			Inline bool   `json:"inline"`
+		} `json:"fields"`
+		Thumbnail struct {
+			URL      string `json:"url"`
+			ProxyURL string `json:"proxy_url"`
+			Width    int    `json:"width"`
+			Height   int    `json:"height"`
+			Flags    int    `json:"flags"`
+		} `json:"thumbnail"`
+	} `json:"embeds"`
+	Timestamp       time.Time `json:"timestamp"`
+	EditedTimestamp any       `json:"edited_timestamp"`
+	Flags           int       `json:"flags"`
+	Components      []any     `json:"components"`
+	ID              string    `json:"id"`
+	ChannelID       string    `json:"channel_id"`
+	Author          struct {
+		ID            string `json:"id"`
+		Username      string `json:"username"`
+		Avatar        any    `json:"avatar"`
+		Discriminator string `json:"discriminator"`
+		PublicFlags   int    `json:"public_flags"`
+		Flags         int    `json:"flags"`
+		Bot           bool   `json:"bot"`
+		GlobalName    any    `json:"global_name"`
+		Clan          any    `json:"clan"`
+	} `json:"author"`
+	Pinned          bool   `json:"pinned"`
+	MentionEveryone bool   `json:"mention_everyone"`
+	Tts             bool   `json:"tts"`
+	WebhookID       string `json:"webhook_id"`
+}
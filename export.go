@@ -0,0 +1,297 @@
+package main
+
+import (
+	"SharepointBot/db"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+)
+
+const (
+	ExportFormatJSONL = "jsonl"
+	ExportFormatCSV   = "csv"
+)
+
+// ExportRecords vrne vsa obvestila z dostavami in različicami v obliki zapisov arhiva.
+func ExportRecords(database db.SQL) ([]ArchiveRecord, error) {
+	notifications, err := database.GetSharepointNotifications()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]ArchiveRecord, 0)
+	for _, notification := range notifications {
+		deliveries, err := database.GetDeliveriesForNotification(notification.ID)
+		if err != nil {
+			return nil, err
+		}
+		revisions, err := database.GetNotificationRevisions(notification.ID)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, ArchiveRecord{Notification: &notification, Deliveries: deliveries, Revisions: revisions})
+	}
+	return records, nil
+}
+
+// ImportRecords doda obvestila, dostave in različice, ki jih v bazi še ni. Obstoječa obvestila in dostave
+// ostanejo nespremenjeni. Vsak zapis se uvozi v svoji transakciji, zato je ponovni uvoz po napaki varen.
+// V outbox ne doda ničesar, zato se nič ne objavi znova.
+func ImportRecords(database db.SQL, records []ArchiveRecord) (int, error) {
+	imported := 0
+	for _, record := range records {
+		inserted := false
+		err := database.WithTx(context.Background(), func(tx db.SQL) error {
+			if record.Notification != nil {
+				_, err := tx.GetSharepointNotification(record.Notification.ID)
+				if errors.Is(err, sql.ErrNoRows) {
+					err = tx.InsertSharepointNotification(*record.Notification)
+					inserted = err == nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+			for _, delivery := range record.Deliveries {
+				err := tx.InsertDelivery(delivery)
+				if err != nil {
+					return err
+				}
+			}
+			for _, revision := range record.Revisions {
+				_, err := tx.InsertNotificationRevision(revision)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return imported, err
+		}
+		if inserted {
+			imported++
+		}
+	}
+	return imported, nil
+}
+
+func WriteJSONL(path string, records []ArchiveRecord) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func ReadJSONL(path string) ([]ArchiveRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := make([]ArchiveRecord, 0)
+	scanner := bufio.NewScanner(f)
+	// obvestila z dolgim besedilom in vsemi različicami so lahko večja od privzetih 64 KiB
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record ArchiveRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// csvColumns vrne imena stolpcev iz oznak db v strukturi.
+func csvColumns(t reflect.Type) []string {
+	columns := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("db")
+		if name != "" && name != "-" {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+func csvValues(v any) []string {
+	value := reflect.ValueOf(v)
+	values := make([]string, 0)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Tag.Get("db")
+		if name == "" || name == "-" {
+			continue
+		}
+		values = append(values, fmt.Sprint(value.Field(i).Interface()))
+	}
+	return values
+}
+
+func csvScan(columns []string, values []string, dest any) error {
+	value := reflect.ValueOf(dest).Elem()
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < value.NumField(); i++ {
+		fields[value.Type().Field(i).Tag.Get("db")] = value.Field(i)
+	}
+	for i, column := range columns {
+		field, ok := fields[column]
+		if !ok || i >= len(values) {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(values[i])
+		case reflect.Int:
+			n, err := strconv.ParseInt(values[i], 10, 64)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", column, err)
+			}
+			field.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(values[i])
+			if err != nil {
+				return fmt.Errorf("column %s: %w", column, err)
+			}
+			field.SetBool(b)
+		}
+	}
+	return nil
+}
+
+func writeCSVFile[T any](path string, rows []T) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	err = w.Write(csvColumns(reflect.TypeFor[T]()))
+	for _, row := range rows {
+		if err != nil {
+			break
+		}
+		err = w.Write(csvValues(row))
+	}
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readCSVFile[T any](path string) ([]T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines, err := csv.NewReader(f).ReadAll()
+	if err != nil || len(lines) == 0 {
+		return nil, err
+	}
+	rows := make([]T, 0)
+	for i, line := range lines[1:] {
+		var row T
+		err = csvScan(lines[0], line, &row)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+2, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// WriteCSV zapiše obvestila, dostave in različice v tri datoteke CSV v mapi dir.
+func WriteCSV(dir string, records []ArchiveRecord) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	notifications := make([]db.SharepointNotification, 0)
+	deliveries := make([]db.Delivery, 0)
+	revisions := make([]db.NotificationRevision, 0)
+	for _, record := range records {
+		if record.Notification != nil {
+			notifications = append(notifications, *record.Notification)
+		}
+		deliveries = append(deliveries, record.Deliveries...)
+		revisions = append(revisions, record.Revisions...)
+	}
+
+	err = writeCSVFile(filepath.Join(dir, "sharepoint_notifications.csv"), notifications)
+	if err != nil {
+		return err
+	}
+	err = writeCSVFile(filepath.Join(dir, "deliveries.csv"), deliveries)
+	if err != nil {
+		return err
+	}
+	return writeCSVFile(filepath.Join(dir, "notification_revisions.csv"), revisions)
+}
+
+func ReadCSV(dir string) ([]ArchiveRecord, error) {
+	notifications, err := readCSVFile[db.SharepointNotification](filepath.Join(dir, "sharepoint_notifications.csv"))
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := readCSVFile[db.Delivery](filepath.Join(dir, "deliveries.csv"))
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := readCSVFile[db.NotificationRevision](filepath.Join(dir, "notification_revisions.csv"))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]ArchiveRecord, 0)
+	index := make(map[string]int)
+	for _, notification := range notifications {
+		index[notification.ID] = len(records)
+		records = append(records, ArchiveRecord{Notification: &notification})
+	}
+	// dostave in različice brez obvestila (npr. iz arhiva obrezanih različic) dobijo svoj zapis
+	recordFor := func(notificationID string) *ArchiveRecord {
+		i, ok := index[notificationID]
+		if !ok {
+			i = len(records)
+			index[notificationID] = i
+			records = append(records, ArchiveRecord{})
+		}
+		return &records[i]
+	}
+	for _, delivery := range deliveries {
+		record := recordFor(delivery.NotificationID)
+		record.Deliveries = append(record.Deliveries, delivery)
+	}
+	for _, revision := range revisions {
+		record := recordFor(revision.NotificationID)
+		record.Revisions = append(record.Revisions, revision)
+	}
+	return records, nil
+}
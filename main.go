@@ -3,8 +3,15 @@ package main
 import (
 	"SharepointBot/config"
 	"SharepointBot/db"
+	"context"
 	"fmt"
 	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	// slika alpine nima podatkov o časovnih pasovih
+	_ "time/tzdata"
 )
 
 func main() {
@@ -16,7 +23,6 @@ func main() {
 	cfg, err := config.GetConfig()
 	if err != nil {
 		panic("Error while retrieving config: " + err.Error())
-		return
 	}
 
 	if cfg.Debug {
@@ -26,21 +32,82 @@ func main() {
 	}
 	if err != nil {
 		panic(err.Error())
-		return
 	}
 
 	sugared := logger.Sugar()
 
-	database, err := db.NewSQL(cfg.DatabaseName, cfg.DatabaseConfig, sugared)
-	database.Init()
+	// healthcheck ne potrebuje baze, preveri le delujoči proces
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		check := ""
+		if len(os.Args) > 2 {
+			check = os.Args[2]
+		}
+		err = Healthcheck(cfg, check)
+		if err != nil {
+			sugared.Fatal("Healthcheck failed: ", err.Error())
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		err = TriggerRemoteSync(cfg)
+		if err != nil {
+			sugared.Fatal("Error while triggering sync: ", err.Error())
+		}
+		fmt.Println("Sync triggered.")
+		return
+	}
+
+	shutdownTracing, err := InitTracing(cfg.Tracing, sugared)
+	if err != nil {
+		sugared.Fatal("Error while setting up tracing: ", err.Error())
+	}
+	// pred izhodom izvozi še neposlane sledi
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}()
 
+	database, err := db.NewSQL(cfg.DatabaseName, cfg.DatabaseConfig, sugared)
 	if err != nil {
 		sugared.Fatal("Error while creating database: ", err.Error())
 		return
 	}
+	// ukaz migrate sam poskrbi za migracije, da lahko prikaže tudi še neizvedene
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		database.Init()
+	}
 
 	sugared.Info("Database created successfully")
 
 	httphandler := NewHTTPInterface(sugared, database, cfg)
-	httphandler.SharepointGoroutine()
+
+	if len(os.Args) > 1 {
+		err = httphandler.RunCommand(os.Args[1:])
+		if err != nil {
+			sugared.Fatal("Error while running command: ", err.Error())
+		}
+		return
+	}
+
+	// korenski kontekst se prekliče ob SIGINT ali SIGTERM (docker stop)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	supervisor := NewSupervisor(sugared)
+	supervisor.Go(ctx, "http", httphandler.HTTPServer)
+	supervisor.Go(ctx, "signal", httphandler.TriggerSyncOnSignal)
+	httphandler.ReconcileOutbox()
+	httphandler.SyncTargets()
+	supervisor.Go(ctx, "outbox", httphandler.OutboxGoroutine)
+	supervisor.Go(ctx, "digest", httphandler.DigestGoroutine)
+	supervisor.Go(ctx, "retention", httphandler.RetentionGoroutine)
+	supervisor.Go(ctx, "sharepoint", httphandler.SharepointGoroutine)
+
+	<-ctx.Done()
+	// ponovni signal takoj konča program
+	stop()
+	sugared.Info("Shutting down, waiting for in-flight work to finish")
+	supervisor.Wait()
+	sugared.Info("Server stopped")
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"SharepointBot/db"
+	"SharepointBot/discord"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ApplyMentions doda omembe cilja v vsebino sporočila. Pingamo le ob objavi,
+// pri urejanju allowed_mentions ostane prazen, da nikogar ne pingnemo znova.
+func (server *httpImpl) ApplyMentions(body discord.WebhookBody, notification db.SharepointNotification, target config.Target, editing bool) discord.WebhookBody {
+	allowed := &discord.AllowedMentions{Parse: make([]string, 0)}
+
+	mentions := make([]string, 0)
+	for _, mention := range target.Mentions {
+		ok, err := matchRegex(mention.Title, notification.Name)
+		if err == nil && ok {
+			ok, err = matchRegex(mention.Body, notification.Description)
+		}
+		if err != nil {
+			server.logger.Errorw("error evaluating mention", "target", target.ID, "notification", notification.ID, "err", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if mention.Everyone && !slices.Contains(allowed.Parse, "everyone") {
+			allowed.Parse = append(allowed.Parse, "everyone")
+			mentions = append(mentions, "@everyone")
+		}
+		for _, role := range mention.Roles {
+			if slices.Contains(allowed.Roles, role) {
+				continue
+			}
+			allowed.Roles = append(allowed.Roles, role)
+			mentions = append(mentions, fmt.Sprintf("<@&%s>", role))
+		}
+		for _, user := range mention.Users {
+			if slices.Contains(allowed.Users, user) {
+				continue
+			}
+			allowed.Users = append(allowed.Users, user)
+			mentions = append(mentions, fmt.Sprintf("<@%s>", user))
+		}
+	}
+
+	if len(mentions) != 0 {
+		body.Content = fmt.Sprintf("%s %s", body.Content, strings.Join(mentions, " "))
+	}
+	if editing {
+		allowed = &discord.AllowedMentions{Parse: make([]string, 0)}
+	}
+	body.AllowedMentions = allowed
+	return body
+}
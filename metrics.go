@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/imroc/req/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+)
+
+var (
+	GraphRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sharepointbot_graph_requests_total",
+		Help: "Microsoft Graph requests by response status code (error when no response was received).",
+	}, []string{"status"})
+	SyncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sharepointbot_sync_duration_seconds",
+		Help:    "Duration of a SharePoint sync over all lists.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"result"})
+	NotificationChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sharepointbot_notifications_total",
+		Help: "Notifications created or updated by sync and deleted by retention.",
+	}, []string{"change"})
+	DeliveryAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sharepointbot_delivery_attempts_total",
+		Help: "Outbox delivery attempts by target, action and outcome (done, retry, uncertain or dead).",
+	}, []string{"target", "action", "outcome"})
+	RateLimitWaits = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sharepointbot_discord_rate_limit_wait_seconds",
+		Help:    "Time spent waiting for Discord rate limits.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+	TokenRefreshFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sharepointbot_token_refresh_failures_total",
+		Help: "Failed Microsoft OAUTH2 token refreshes.",
+	})
+	GoroutineRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sharepointbot_goroutine_restarts_total",
+		Help: "Goroutines restarted by the supervisor after an error or panic.",
+	}, []string{"goroutine"})
+)
+
+// countGraphRequests šteje vse zahteve na Graph, tudi ponovljene in neuspele.
+func countGraphRequests(rt req.RoundTripper) req.RoundTripFunc {
+	return func(r *req.Request) (*req.Response, error) {
+		resp, err := rt.RoundTrip(r)
+		status := "error"
+		if err == nil && resp != nil && resp.Response != nil {
+			status = strconv.Itoa(resp.StatusCode)
+		}
+		GraphRequests.WithLabelValues(status).Inc()
+		return resp, err
+	}
+}
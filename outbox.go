@@ -0,0 +1,370 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"SharepointBot/db"
+	"SharepointBot/discord"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+var (
+	OutboxPollInterval = 10 * time.Second
+	OutboxBaseBackoff  = 30 * time.Second
+	OutboxMaxBackoff   = time.Hour
+)
+
+func OutboxBackoff(attempts int) time.Duration {
+	return Backoff(attempts, OutboxBaseBackoff, OutboxMaxBackoff)
+}
+
+var ErrUnknownTarget = errors.New("target is not configured")
+
+// ErrUncertainPost pomeni, da je objava morda prispela. Ponovna objava bi lahko ustvarila dvojnik,
+// zato jo označimo kot negotovo, operater pa jo lahko ponovi ročno.
+var ErrUncertainPost = errors.New("post may have been delivered")
+
+// uncertainPost označi napako objave, pri kateri ne vemo, ali je sporočilo prispelo.
+func uncertainPost(err error) error {
+	if errors.Is(err, discord.ErrUncertain) {
+		return fmt.Errorf("%w: %w", ErrUncertainPost, err)
+	}
+	return err
+}
+
+func (server *httpImpl) EnqueueDelivery(notificationID string, targetID string, action string, messageID string) {
+	server.EnqueueDeliveryAt(notificationID, targetID, action, messageID, time.Now())
+}
+
+// NewOutboxEntry pripravi dostavo, ki se izvede ob at. Za vpis skupaj z obvestilom v isti transakciji.
+func NewOutboxEntry(notificationID string, targetID string, action string, messageID string, at time.Time) db.OutboxEntry {
+	now := int(time.Now().Unix())
+	return db.OutboxEntry{
+		NotificationID: notificationID,
+		TargetID:       targetID,
+		Action:         action,
+		MessageID:      messageID,
+		Status:         db.OutboxStatusPending,
+		NextAttemptOn:  int(at.Unix()),
+		CreatedOn:      now,
+		UpdatedOn:      now,
+	}
+}
+
+func (server *httpImpl) EnqueueDeliveryAt(notificationID string, targetID string, action string, messageID string, at time.Time) {
+	err := server.db.InsertOutboxEntry(NewOutboxEntry(notificationID, targetID, action, messageID, at))
+	if err != nil {
+		server.logger.Errorw("error enqueueing delivery", "notification", notificationID, "target", targetID, "action", action, "err", err)
+	}
+}
+
+// recordDelivery shrani stanje dostave za cilj.
+func recordDelivery(database db.SQL, delivery db.Delivery) error {
+	now := int(time.Now().Unix())
+	delivery.CreatedOn = now
+	delivery.UpdatedOn = now
+	return database.UpsertDelivery(delivery)
+}
+
+// NewRepostEntry pripravi ponovno objavo posodobljenega obvestila s povzetkom sprememb.
+func NewRepostEntry(notificationID string, targetID string, messageID string, summary string) db.OutboxEntry {
+	entry := NewOutboxEntry(notificationID, targetID, db.OutboxActionRepost, messageID, time.Now())
+	entry.Summary = summary
+	return entry
+}
+
+// retryDB ponovi zapis v bazo, ko je bilo sporočilo že poslano, saj ponovno pošiljanje ni dovoljeno.
+func (server *httpImpl) retryDB(f func() error) (err error) {
+	for i := 0; i < 5; i++ {
+		err = f()
+		if err == nil {
+			return nil
+		}
+		server.logger.Warnw("error writing delivery state, retrying", "attempt", i+1, "err", err)
+		time.Sleep(time.Duration(i+1) * time.Second)
+	}
+	return err
+}
+
+// deliver izvede dostavo in vrne novo stanje dostave za cilj.
+func (server *httpImpl) deliver(ctx context.Context, entry db.OutboxEntry) (db.Delivery, error) {
+	delivery := db.Delivery{
+		NotificationID: entry.NotificationID,
+		TargetID:       entry.TargetID,
+		MessageID:      entry.MessageID,
+		Status:         db.DeliveryStatusPosted,
+	}
+
+	target, ok := server.config.GetTarget(entry.TargetID)
+	if !ok {
+		return delivery, fmt.Errorf("%w: %s", ErrUnknownTarget, entry.TargetID)
+	}
+
+	if entry.Action == db.OutboxActionDigest || entry.Action == db.OutboxActionDigestEdit {
+		return server.deliverDigest(ctx, target, entry)
+	}
+
+	if entry.Action == db.OutboxActionDelete {
+		err := server.DeleteMessageFromWebhook(ctx, target, entry.NotificationID, entry.MessageID)
+		// sporočilo je že izbrisano
+		if errors.Is(err, discord.ErrUnknownMessage) {
+			return delivery, nil
+		}
+		return delivery, err
+	}
+
+	notification, err := server.db.WithContext(ctx).GetSharepointNotification(entry.NotificationID)
+	if err != nil {
+		return delivery, err
+	}
+	// spremembe prikažemo glede na različico, ki so jo bralci videli v sporočilu, ob prvi objavi pa nič
+	existing, err := server.db.WithContext(ctx).GetDelivery(entry.NotificationID, entry.TargetID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return delivery, err
+	}
+	found := err == nil
+	changes := ""
+	if found && entry.Action != db.OutboxActionPost {
+		changes = RenderChanges(PostedVersion(existing), CurrentVersion(notification))
+	}
+	body := server.ApplyMentions(RenderNotification(notification, changes), notification, target, entry.Action != db.OutboxActionPost)
+	delivery.RenderedHash = RenderedHash(body)
+	setPostedVersion(&delivery, CurrentVersion(notification))
+
+	switch entry.Action {
+	case db.OutboxActionPost:
+		delivery.MessageID, err = server.SendNotificationToWebhook(ctx, target, "", notification.ID, body)
+		return delivery, uncertainPost(err)
+	case db.OutboxActionRepost:
+		repost := RenderNotification(notification, changes)
+		repost.Content = "Posodobljeno obvestilo na intranetu"
+		if entry.Summary != "" {
+			repost.Embeds[0].Fields = append(repost.Embeds[0].Fields, discord.EmbedField{Name: "Povzetek sprememb", Value: entry.Summary})
+		}
+		repost = server.ApplyMentions(repost, notification, target, true)
+		delivery.MessageID, err = server.SendNotificationToWebhook(ctx, target, "", notification.ID, repost)
+		return delivery, uncertainPost(err)
+	case db.OutboxActionEdit:
+		// urejeno sporočilo ostane isto, zato ohrani osnovo za spremembe
+		if found {
+			setPostedVersion(&delivery, PostedVersion(existing))
+		}
+		if found && existing.RenderedHash == delivery.RenderedHash {
+			server.logger.Infow("rendered message did not change, skipping edit", "notification", notification.ID, "target", target.ID)
+			return delivery, nil
+		}
+
+		_, err = server.SendNotificationToWebhook(ctx, target, entry.MessageID, notification.ID, body)
+		if !errors.Is(err, discord.ErrUnknownMessage) {
+			return delivery, err
+		}
+
+		// moderator je sporočilo izbrisal
+		if target.OnDeleted == config.OnDeletedRepost {
+			server.logger.Infow("message was deleted on Discord, reposting", "notification", notification.ID, "target", target.ID, "message", entry.MessageID)
+			// ponovna objava je posledica urejanja, zato nikogar ne pingnemo znova
+			delivery.MessageID, err = server.SendNotificationToWebhook(ctx, target, "", notification.ID, body)
+			return delivery, uncertainPost(err)
+		}
+		server.logger.Infow("message was deleted on Discord, marking delivery as removed", "notification", notification.ID, "target", target.ID, "message", entry.MessageID)
+		delivery.Status = db.DeliveryStatusRemoved
+		return delivery, nil
+	}
+
+	return delivery, errors.New("unknown outbox action " + entry.Action)
+}
+
+// setPostedVersion nastavi različico, ki jo prikazuje sporočilo dostave.
+func setPostedVersion(delivery *db.Delivery, version Version) {
+	delivery.PostedName = version.Name
+	delivery.PostedDescription = version.Description
+	delivery.PostedExpiresOn = version.ExpiresOn
+}
+
+func (server *httpImpl) ProcessOutboxEntry(entry db.OutboxEntry) {
+	// nova obvestila izven časovnega okna cilja počakajo na začetek okna
+	if entry.Action == db.OutboxActionPost {
+		target, _ := server.config.GetTarget(entry.TargetID)
+		next, err := NextWindowStart(target.Window, server.config.GetLocation(), time.Now())
+		if err != nil {
+			server.logger.Errorw("invalid delivery window", "target", target.ID, "err", err)
+		} else if next.After(time.Now()) {
+			server.logger.Infow("outside of delivery window, postponing", "id", entry.ID, "notification", entry.NotificationID, "target", entry.TargetID, "until", next)
+			entry.NextAttemptOn = int(next.Unix())
+			entry.UpdatedOn = int(time.Now().Unix())
+			err = server.db.UpdateOutboxEntry(entry)
+			if err != nil {
+				server.logger.Errorw("error postponing outbox entry", "id", entry.ID, "err", err)
+			}
+			return
+		}
+	}
+
+	// namen zapišemo pred pošiljanjem, da po sesutju vemo, katere dostave so bile na poti
+	entry.Status = db.OutboxStatusSending
+	entry.UpdatedOn = int(time.Now().Unix())
+	err := server.db.UpdateOutboxEntry(entry)
+	if err != nil {
+		server.logger.Errorw("error marking outbox entry as sending", "id", entry.ID, "err", err)
+		return
+	}
+
+	ctx, span := tracer.Start(context.Background(), "ProcessOutboxEntry", trace.WithAttributes(
+		AttrOutboxID.String(entry.ID),
+		AttrOutboxAction.String(entry.Action),
+		AttrNotificationID.String(entry.NotificationID),
+		AttrTargetID.String(entry.TargetID),
+		attribute.Int("outbox.attempt", entry.Attempts+1),
+	))
+	delivery, err := server.deliver(ctx, entry)
+	endSpan(span, err)
+
+	now := time.Now()
+	entry.Attempts++
+	entry.UpdatedOn = int(now.Unix())
+	if err == nil {
+		if delivery.MessageID != entry.MessageID {
+			entry.MessageID = delivery.MessageID
+			// ID sporočila shranimo takoj, preden karkoli drugega, da ga ob sesutju ne pošljemo znova
+			err = server.retryDB(func() error { return server.db.UpdateOutboxEntry(entry) })
+			if err != nil {
+				server.logger.Errorw("message was posted but could not be recorded", "id", entry.ID, "notification", entry.NotificationID, "target", entry.TargetID, "message", delivery.MessageID, "err", err)
+				return
+			}
+		}
+		entry.Status = db.OutboxStatusDone
+		entry.LastError = ""
+		DeliveryAttempts.WithLabelValues(entry.TargetID, entry.Action, "done").Inc()
+		// dostava in končno stanje vnosa se zapišeta skupaj
+		err = server.retryDB(func() error {
+			return server.db.WithTx(context.Background(), func(tx db.SQL) error {
+				var err error
+				switch entry.Action {
+				case db.OutboxActionDelete:
+				case db.OutboxActionDigest, db.OutboxActionDigestEdit:
+					err = recordDigestDelivery(tx, delivery)
+				default:
+					err = recordDelivery(tx, delivery)
+				}
+				if err != nil {
+					return err
+				}
+				return tx.UpdateOutboxEntry(entry)
+			})
+		})
+		if err != nil {
+			server.logger.Errorw("error recording delivery", "id", entry.ID, "notification", entry.NotificationID, "message", entry.MessageID, "err", err)
+		}
+		return
+	} else if errors.Is(err, ErrUncertainPost) {
+		server.logger.Warnw("post may have been delivered, marking as uncertain", "id", entry.ID, "notification", entry.NotificationID, "target", entry.TargetID, "action", entry.Action, "attempts", entry.Attempts, "err", err)
+		entry.Status = db.OutboxStatusUncertain
+		entry.LastError = err.Error()
+		DeliveryAttempts.WithLabelValues(entry.TargetID, entry.Action, "uncertain").Inc()
+	} else if entry.Attempts >= server.config.OutboxMaxAttempts || discord.IsPermanent(err) || errors.Is(err, ErrUnknownTarget) {
+		server.logger.Errorw("delivery failed permanently, moving to dead letters", "id", entry.ID, "notification", entry.NotificationID, "target", entry.TargetID, "action", entry.Action, "attempts", entry.Attempts, "err", err)
+		entry.Status = db.OutboxStatusDead
+		entry.LastError = err.Error()
+		DeliveryAttempts.WithLabelValues(entry.TargetID, entry.Action, "dead").Inc()
+	} else {
+		backoff := OutboxBackoff(entry.Attempts)
+		server.logger.Warnw("delivery failed, retrying later", "id", entry.ID, "notification", entry.NotificationID, "target", entry.TargetID, "action", entry.Action, "attempts", entry.Attempts, "backoff", backoff, "err", err)
+		entry.Status = db.OutboxStatusPending
+		entry.NextAttemptOn = int(now.Add(backoff).Unix())
+		entry.LastError = err.Error()
+		DeliveryAttempts.WithLabelValues(entry.TargetID, entry.Action, "retry").Inc()
+	}
+
+	err = server.retryDB(func() error { return server.db.UpdateOutboxEntry(entry) })
+	if err != nil {
+		server.logger.Errorw("error updating outbox entry", "id", entry.ID, "err", err)
+	}
+}
+
+// ReconcileOutbox ob zagonu razreši dostave, ki jih je prekinilo sesutje.
+func (server *httpImpl) ReconcileOutbox() {
+	entries, err := server.db.GetOutboxEntriesByStatus(db.OutboxStatusSending)
+	if err != nil {
+		server.logger.Errorw("error retrieving in-flight outbox entries", "err", err)
+		return
+	}
+
+	for _, entry := range entries {
+		entry.UpdatedOn = int(time.Now().Unix())
+		switch {
+		case (entry.Action == db.OutboxActionPost || entry.Action == db.OutboxActionDigest) && entry.MessageID != "":
+			// sporočilo je bilo objavljeno, manjka le še zapis dostave
+			delivery := db.Delivery{
+				NotificationID: entry.NotificationID,
+				TargetID:       entry.TargetID,
+				MessageID:      entry.MessageID,
+				Status:         db.DeliveryStatusPosted,
+			}
+			if entry.Action == db.OutboxActionDigest {
+				err = recordDigestDelivery(server.db, delivery)
+			} else {
+				err = recordDelivery(server.db, delivery)
+			}
+			if err != nil {
+				server.logger.Errorw("error recording delivery", "id", entry.ID, "err", err)
+				continue
+			}
+			entry.Status = db.OutboxStatusDone
+		case entry.Action == db.OutboxActionPost || entry.Action == db.OutboxActionRepost || entry.Action == db.OutboxActionDigest:
+			// Discord webhooki ne podpirajo nonce-a, zato ne moremo preveriti, ali je objava prispela.
+			// Raje ne objavimo ponovno, operater lahko dostavo ponovi ročno.
+			server.logger.Warnw("post was interrupted, marking as uncertain", "id", entry.ID, "notification", entry.NotificationID, "target", entry.TargetID)
+			entry.Status = db.OutboxStatusUncertain
+		default:
+			// urejanje in brisanje sta idempotentna
+			entry.Status = db.OutboxStatusPending
+		}
+		err = server.db.UpdateOutboxEntry(entry)
+		if err != nil {
+			server.logger.Errorw("error updating outbox entry", "id", entry.ID, "err", err)
+		}
+	}
+
+}
+
+func (server *httpImpl) OutboxGoroutine(ctx context.Context) error {
+	server.logger.Infow("starting outbox goroutine")
+
+	for {
+		entries, err := server.db.GetDueOutboxEntries(int(time.Now().Unix()))
+		if err != nil {
+			server.logger.Errorw("error retrieving due outbox entries", "err", err)
+		}
+		for _, entry := range entries {
+			// ob zaustavitvi ne začnemo novih dostav, začeta pa se izvede do konca
+			if ctx.Err() != nil {
+				break
+			}
+			server.ProcessOutboxEntry(entry)
+		}
+		if !sleep(ctx, OutboxPollInterval) {
+			server.logger.Infow("exiting outbox goroutine")
+			return nil
+		}
+	}
+}
+
+func (server *httpImpl) ReplayOutboxEntry(id string) error {
+	entry, err := server.db.GetOutboxEntry(id)
+	if err != nil {
+		return err
+	}
+	if entry.Status != db.OutboxStatusDead && entry.Status != db.OutboxStatusUncertain {
+		return fmt.Errorf("outbox entry %s is not a dead letter (status %s)", id, entry.Status)
+	}
+	entry.Status = db.OutboxStatusPending
+	entry.Attempts = 0
+	entry.NextAttemptOn = int(time.Now().Unix())
+	entry.UpdatedOn = entry.NextAttemptOn
+	return server.db.UpdateOutboxEntry(entry)
+}
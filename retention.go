@@ -0,0 +1,181 @@
+package main
+
+import (
+	"SharepointBot/db"
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+var RetentionPollInterval = 24 * time.Hour
+
+// ArchiveRecord je ena vrstica arhiva. Pri obrezovanju različic je Notification prazen.
+type ArchiveRecord struct {
+	Notification *db.SharepointNotification `json:"notification,omitempty"`
+	Deliveries   []db.Delivery              `json:"deliveries,omitempty"`
+	Revisions    []db.NotificationRevision  `json:"revisions,omitempty"`
+	ArchivedOn   int                        `json:"archived_on"`
+}
+
+// Prunable vrne true, če je obvestilo poteklo pred več kot Retention.ExpiredDays dnevi.
+func (server *httpImpl) Prunable(expiresOn int, now time.Time) bool {
+	days := server.config.Retention.ExpiredDays
+	if days <= 0 || expiresOn == 0 {
+		return false
+	}
+	return expiresOn < int(now.AddDate(0, 0, -days).Unix())
+}
+
+// archive doda zapise na konec arhiva. Brez nastavljenega arhiva ne naredi ničesar.
+func (server *httpImpl) archive(records ...ArchiveRecord) error {
+	if server.config.Retention.Archive == "" || len(records) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(server.config.Retention.Archive, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	// vrstice morajo biti na disku, preden jih izbrišemo iz baze
+	err = f.Sync()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// PruneNotification arhivira in izbriše obvestilo z vsemi dostavami, različicami in vnosi v outboxu.
+func (server *httpImpl) PruneNotification(notification db.SharepointNotification) error {
+	deliveries, err := server.db.GetDeliveriesForNotification(notification.ID)
+	if err != nil {
+		return err
+	}
+	revisions, err := server.db.GetNotificationRevisions(notification.ID)
+	if err != nil {
+		return err
+	}
+	err = server.archive(ArchiveRecord{
+		Notification: &notification,
+		Deliveries:   deliveries,
+		Revisions:    revisions,
+		ArchivedOn:   int(time.Now().Unix()),
+	})
+	if err != nil {
+		return err
+	}
+
+	return server.db.WithTx(context.Background(), func(tx db.SQL) error {
+		err := tx.DeleteOutboxEntriesForNotification(notification.ID)
+		if err != nil {
+			return err
+		}
+		for _, delivery := range deliveries {
+			if server.config.Retention.DeleteMessages && delivery.Status == db.DeliveryStatusPosted && delivery.MessageID != "" {
+				err = tx.InsertOutboxEntry(NewOutboxEntry(notification.ID, delivery.TargetID, db.OutboxActionDelete, delivery.MessageID, time.Now()))
+				if err != nil {
+					return err
+				}
+			}
+			err = tx.DeleteDelivery(notification.ID, delivery.TargetID)
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.DeleteNotificationRevisions(notification.ID)
+		if err != nil {
+			return err
+		}
+		// sled, da elementa s SharePointa ne prenašamo ob vsakem preverjanju
+		err = tx.UpsertPrunedNotification(db.PrunedNotification{
+			ID:         notification.ID,
+			ModifiedOn: notification.ModifiedOn,
+			CreatedOn:  int(time.Now().Unix()),
+		})
+		if err != nil {
+			return err
+		}
+		return tx.DeleteSharepointNotification(notification.ID)
+	})
+}
+
+// PruneRevisions arhivira in izbriše različice nad Retention.Revisions za vsako obvestilo.
+func (server *httpImpl) PruneRevisions() (int, error) {
+	if server.config.Retention.Revisions <= 0 {
+		return 0, nil
+	}
+	revisions, err := server.db.GetExcessNotificationRevisions(server.config.Retention.Revisions)
+	if err != nil || len(revisions) == 0 {
+		return 0, err
+	}
+
+	records := make([]ArchiveRecord, 0)
+	now := int(time.Now().Unix())
+	for _, revision := range revisions {
+		if len(records) != 0 && records[len(records)-1].Revisions[0].NotificationID == revision.NotificationID {
+			records[len(records)-1].Revisions = append(records[len(records)-1].Revisions, revision)
+			continue
+		}
+		records = append(records, ArchiveRecord{Revisions: []db.NotificationRevision{revision}, ArchivedOn: now})
+	}
+	err = server.archive(records...)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, revision := range revisions {
+		err = server.db.DeleteNotificationRevision(revision.NotificationID, revision.Version)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(revisions), nil
+}
+
+// Prune izvede pravila hranjenja in vrne število izbrisanih obvestil in različic.
+func (server *httpImpl) Prune() (int, int, error) {
+	now := time.Now()
+	pruned := 0
+	if server.config.Retention.ExpiredDays > 0 {
+		notifications, err := server.db.GetExpiredSharepointNotifications(int(now.AddDate(0, 0, -server.config.Retention.ExpiredDays).Unix()))
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, notification := range notifications {
+			err = server.PruneNotification(notification)
+			if err != nil {
+				return pruned, 0, err
+			}
+			pruned++
+			NotificationChanges.WithLabelValues("deleted").Inc()
+		}
+	}
+
+	revisions, err := server.PruneRevisions()
+	return pruned, revisions, err
+}
+
+func (server *httpImpl) RetentionGoroutine(ctx context.Context) error {
+	server.logger.Infow("starting retention goroutine")
+
+	for {
+		notifications, revisions, err := server.Prune()
+		if err != nil {
+			server.logger.Errorw("error pruning archive", "notifications", notifications, "revisions", revisions, "err", err)
+		} else if notifications != 0 || revisions != 0 {
+			server.logger.Infow("pruned archive", "notifications", notifications, "revisions", revisions)
+		}
+		if !sleep(ctx, RetentionPollInterval) {
+			server.logger.Infow("exiting retention goroutine")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"SharepointBot/db"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+func matchRegex(pattern string, value string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return r.MatchString(value), nil
+}
+
+// MatchRoute preveri, ali obvestilo ustreza vsem pogojem pravila.
+func MatchRoute(route config.Route, notification db.SharepointNotification) (bool, error) {
+	list := notification.ListID
+	if list == "" {
+		list = config.DefaultList
+	}
+	if route.List != "" && route.List != list {
+		return false, nil
+	}
+	if route.Attachments != nil && *route.Attachments != notification.HasAttachments {
+		return false, nil
+	}
+
+	checks := [][2]string{
+		{route.Title, notification.Name},
+		{route.Body, notification.Description},
+		{route.Author, notification.CreatedBy},
+		{route.ContentType, notification.ContentType},
+	}
+
+	if len(route.Fields) != 0 {
+		fields := make(map[string]any)
+		if notification.Fields != "" {
+			err := json.Unmarshal([]byte(notification.Fields), &fields)
+			if err != nil {
+				return false, err
+			}
+		}
+		for column, pattern := range route.Fields {
+			value, ok := fields[column]
+			if !ok {
+				return false, nil
+			}
+			checks = append(checks, [2]string{pattern, fmt.Sprint(value)})
+		}
+	}
+
+	for _, check := range checks {
+		ok, err := matchRegex(check[0], check[1])
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// RouteNotification vrne cilje, ki naj prejmejo obvestilo.
+func (server *httpImpl) RouteNotification(notification db.SharepointNotification) []config.Target {
+	targets := server.config.GetTargets()
+	if len(server.config.Routes) == 0 {
+		return targets
+	}
+
+	ids := make([]string, 0)
+	for _, route := range server.config.Routes {
+		ok, err := MatchRoute(route, notification)
+		if err != nil {
+			server.logger.Errorw("error evaluating route", "route", route.Name, "notification", notification.ID, "err", err)
+			continue
+		}
+		if ok {
+			ids = append(ids, route.Targets...)
+		}
+	}
+
+	routed := make([]config.Target, 0)
+	for _, target := range targets {
+		if slices.Contains(ids, target.ID) {
+			routed = append(routed, target)
+		}
+	}
+	return routed
+}
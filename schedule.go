@@ -0,0 +1,103 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// NextSync vrne trenutek naslednjega preverjanja po urniku, brez naključnega zamika.
+// Ob neveljavnem urniku vrne napako in trenutek po intervalu.
+func NextSync(schedule config.Schedule, location *time.Location, now time.Time) (time.Time, error) {
+	fallback := now.Add(time.Duration(schedule.IntervalMinutes) * time.Minute)
+	if len(schedule.Cron) == 0 {
+		return fallback, nil
+	}
+
+	var next time.Time
+	for _, spec := range schedule.Cron {
+		s, err := cron.ParseStandard(spec)
+		if err != nil {
+			return fallback, fmt.Errorf("invalid cron expression %q: %w", spec, err)
+		}
+		t := s.Next(now.In(location))
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if next.IsZero() {
+		return fallback, errors.New("cron expressions never match")
+	}
+	return next, nil
+}
+
+func jitter(schedule config.Schedule) time.Duration {
+	if schedule.JitterSeconds <= 0 {
+		return 0
+	}
+	return rand.N(time.Duration(schedule.JitterSeconds) * time.Second)
+}
+
+// TriggerSync sproži takojšnje preverjanje. Če eno že čaka, ne sproži še enega.
+func (server *httpImpl) TriggerSync(reason string) {
+	select {
+	case server.syncNow <- struct{}{}:
+		server.logger.Infow("Sharepoint sync triggered", "reason", reason)
+	default:
+		server.logger.Infow("Sharepoint sync already triggered", "reason", reason)
+	}
+}
+
+// waitForSync počaka do naslednjega preverjanja po urniku, do sprožitve ali do zaustavitve.
+func (server *httpImpl) waitForSync(ctx context.Context) {
+	next, err := NextSync(server.config.Schedule, server.config.GetLocation(), time.Now())
+	if err != nil {
+		server.logger.Errorw("invalid sync schedule, falling back to interval", "interval", server.config.Schedule.IntervalMinutes, "err", err)
+	}
+	next = next.Add(jitter(server.config.Schedule))
+	server.status.recordNextSync(next)
+	server.logger.Infow("waiting for next Sharepoint sync", "next", next)
+	server.waitUntil(ctx, next)
+}
+
+// waitUntil počaka do podanega trenutka, do sprožitve preverjanja ali do zaustavitve.
+func (server *httpImpl) waitUntil(ctx context.Context, t time.Time) {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-server.syncNow:
+	case <-ctx.Done():
+	}
+}
+
+// TriggerRemoteSync sproži preverjanje v delujočem procesu prek /admin/sync.
+func TriggerRemoteSync(cfg config.Config) error {
+	url, err := localURL(cfg, "/admin/sync")
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	if cfg.AdminToken != "" {
+		r.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("/admin/sync responded with status code %d", res.StatusCode)
+	}
+	return nil
+}
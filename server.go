@@ -3,24 +3,65 @@ package main
 import (
 	"SharepointBot/config"
 	"SharepointBot/db"
+	"SharepointBot/discord"
+	"context"
 	"go.uber.org/zap"
+	"time"
 )
 
 type httpImpl struct {
-	logger *zap.SugaredLogger
-	db     db.SQL
-	config config.Config
+	logger  *zap.SugaredLogger
+	db      db.SQL
+	config  config.Config
+	discord *discord.Client
+	status  *SyncStatus
+	syncNow chan struct{}
 }
 
 type HTTP interface {
 	// sharepoint.go
-	SharepointGoroutine()
+	SharepointGoroutine(ctx context.Context) error
+
+	// schedule.go, signal_unix.go
+	TriggerSync(reason string)
+	TriggerSyncOnSignal(ctx context.Context) error
+
+	// outbox.go
+	ReconcileOutbox()
+	OutboxGoroutine(ctx context.Context) error
+
+	// routing.go
+	RouteNotification(notification db.SharepointNotification) []config.Target
+
+	// digest.go
+	DigestGoroutine(ctx context.Context) error
+
+	// retention.go
+	RetentionGoroutine(ctx context.Context) error
+
+	// status.go
+	HTTPServer(ctx context.Context) error
+
+	// targets.go
+	SyncTargets()
+
+	// cli.go
+	RunCommand(args []string) error
 }
 
 func NewHTTPInterface(logger *zap.SugaredLogger, db db.SQL, config config.Config) HTTP {
+	client := discord.NewClient(logger, config.Debug)
+	client.OnRateLimit = func(route string, wait time.Duration) {
+		RateLimitWaits.Observe(wait.Seconds())
+	}
+	status := &SyncStatus{}
+	status.recordRefreshToken(config.MicrosoftOAUTH2RefreshToken)
 	return &httpImpl{
-		logger: logger,
-		db:     db,
-		config: config,
+		logger:  logger,
+		db:      db,
+		config:  config,
+		discord: client,
+		status:  status,
+		syncNow: make(chan struct{}, 1),
 	}
 }
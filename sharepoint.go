@@ -3,22 +3,34 @@ package main
 import (
 	"SharepointBot/config"
 	"SharepointBot/db"
+	"SharepointBot/discord"
 	"bufio"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	md "github.com/JohannesKaufmann/html-to-markdown"
 	"github.com/imroc/req/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var SCOPE = "https://graph.microsoft.com/Files.Read.All https://graph.microsoft.com/Sites.Read.All"
 
+const (
+	FieldModifiedOn = "Nazadnje spremenjeno"
+	FieldModifiedBy = "Nazadnje spremenil"
+)
+
 type OAUTH2CallbackBody struct {
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
@@ -37,38 +49,40 @@ type MicrosoftOUATH2Response struct {
 }
 
 type SharepointResponse struct {
-	OdataContext  string `json:"@odata.context"`
-	OdataNextLink string `json:"@odata.nextLink"`
-	Value         []struct {
-		OdataEtag            string    `json:"@odata.etag"`
-		CreatedDateTime      time.Time `json:"createdDateTime"`
-		ETag                 string    `json:"eTag"`
-		Id                   string    `json:"id"`
-		LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
-		WebUrl               string    `json:"webUrl"`
-		CreatedBy            struct {
-			User struct {
-				Email       string `json:"email"`
-				Id          string `json:"id"`
-				DisplayName string `json:"displayName"`
-			} `json:"user"`
-		} `json:"createdBy"`
-		LastModifiedBy struct {
-			User struct {
-				Email       string `json:"email"`
-				Id          string `json:"id"`
-				DisplayName string `json:"displayName"`
-			} `json:"user"`
-		} `json:"lastModifiedBy"`
-		ParentReference struct {
-			Id     string `json:"id"`
-			SiteId string `json:"siteId"`
-		} `json:"parentReference"`
-		ContentType struct {
-			Id   string `json:"id"`
-			Name string `json:"name"`
-		} `json:"contentType"`
-	} `json:"value"`
+	OdataContext  string           `json:"@odata.context"`
+	OdataNextLink string           `json:"@odata.nextLink"`
+	Value         []SharepointItem `json:"value"`
+}
+
+type SharepointItem struct {
+	OdataEtag            string    `json:"@odata.etag"`
+	CreatedDateTime      time.Time `json:"createdDateTime"`
+	ETag                 string    `json:"eTag"`
+	Id                   string    `json:"id"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	WebUrl               string    `json:"webUrl"`
+	CreatedBy            struct {
+		User struct {
+			Email       string `json:"email"`
+			Id          string `json:"id"`
+			DisplayName string `json:"displayName"`
+		} `json:"user"`
+	} `json:"createdBy"`
+	LastModifiedBy struct {
+		User struct {
+			Email       string `json:"email"`
+			Id          string `json:"id"`
+			DisplayName string `json:"displayName"`
+		} `json:"user"`
+	} `json:"lastModifiedBy"`
+	ParentReference struct {
+		Id     string `json:"id"`
+		SiteId string `json:"siteId"`
+	} `json:"parentReference"`
+	ContentType struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"contentType"`
 }
 
 type SharepointNotificationResponse struct {
@@ -128,99 +142,20 @@ type SharepointNotificationResponse struct {
 	} `json:"fields"`
 }
 
-type EmbedField struct {
-	Name   string `json:"name"`
-	Value  string `json:"value"`
-	Inline bool   `json:"inline,omitempty"`
-}
-
-type Embed struct {
-	Author struct {
-		Name    string `json:"name"`
-		URL     string `json:"url"`
-		IconURL string `json:"icon_url"`
-	} `json:"author"`
-	Title       string       `json:"title"`
-	URL         string       `json:"url"`
-	Description string       `json:"description"`
-	Color       int          `json:"color"`
-	Fields      []EmbedField `json:"fields"`
-	Thumbnail   struct {
-		URL string `json:"url"`
-	} `json:"thumbnail"`
-	Image struct {
-		URL string `json:"url"`
-	} `json:"image"`
-	Footer struct {
-		Text    string `json:"text"`
-		IconURL string `json:"icon_url"`
-	} `json:"footer"`
-}
-
-type WebhookBody struct {
-	Username  string  `json:"username"`
-	AvatarURL string  `json:"avatar_url"`
-	Content   string  `json:"content"`
-	Embeds    []Embed `json:"embeds"`
-}
-
-type DiscordWebhookResponse struct {
-	Type         int    `json:"type"`
-	Content      string `json:"content"`
-	Mentions     []any  `json:"mentions"`
-	MentionRoles []any  `json:"mention_roles"`
-	Attachments  []any  `json:"attachments"`
-	Embeds       []struct {
-		Type   string `json:"type"`
-		URL    string `json:"url"`
-		Color  int    `json:"color"`
-		Fields []struct {
-			Name   string `json:"name"`
-			Value  string `json:"value"`
-			Inline bool   `json:"inline"`
-		} `json:"fields"`
-		Thumbnail struct {
-			URL      string `json:"url"`
-			ProxyURL string `json:"proxy_url"`
-			Width    int    `json:"width"`
-			Height   int    `json:"height"`
-			Flags    int    `json:"flags"`
-		} `json:"thumbnail"`
-	} `json:"embeds"`
-	Timestamp       time.Time `json:"timestamp"`
-	EditedTimestamp any       `json:"edited_timestamp"`
-	Flags           int       `json:"flags"`
-	Components      []any     `json:"components"`
-	ID              string    `json:"id"`
-	ChannelID       string    `json:"channel_id"`
-	Author          struct {
-		ID            string `json:"id"`
-		Username      string `json:"username"`
-		Avatar        any    `json:"avatar"`
-		Discriminator string `json:"discriminator"`
-		PublicFlags   int    `json:"public_flags"`
-		Flags         int    `json:"flags"`
-		Bot           bool   `json:"bot"`
-		GlobalName    any    `json:"global_name"`
-		Clan          any    `json:"clan"`
-	} `json:"author"`
-	Pinned          bool   `json:"pinned"`
-	MentionEveryone bool   `json:"mention_everyone"`
-	Tts             bool   `json:"tts"`
-	WebhookID       string `json:"webhook_id"`
-}
-
-func (server *httpImpl) SendNotificationToWebhook(webhook string, editing bool, notification db.SharepointNotification) string {
-	if !editing {
-		webhook += "?wait=true"
+func NotificationURL(notification db.SharepointNotification) string {
+	if notification.ListID != config.DefaultList && notification.ListID != "" && notification.WebURL != "" {
+		return notification.WebURL
 	}
+	return fmt.Sprintf("https://gimnazijabezigrad.sharepoint.com/Lists/ObvAkt/DispForm.aspx?ID=%s", notification.ID)
+}
 
+// RenderNotification izriše sporočilo za obvestilo. changes je polje "Spremembe" (glej RenderChanges),
+// ob prvi objavi je prazno.
+func RenderNotification(notification db.SharepointNotification, changes string) discord.WebhookBody {
 	if len([]rune(notification.Description)) > 4096 {
 		notification.Description = string([]rune(notification.Description)[0:4093]) + "..."
 	}
 
-	request := req.C().DevMode().R()
-
 	createdOn := time.Unix(int64(notification.CreatedOn), 0)
 	created := createdOn.Format("02. 01. 2006 ob 15.04")
 
@@ -235,97 +170,185 @@ func (server *httpImpl) SendNotificationToWebhook(webhook string, editing bool,
 		description += "*Obvestilo ima priponke.*"
 	}
 
-	body := WebhookBody{
+	fields := []discord.EmbedField{
+		{
+			Name:   "Ustvarjeno",
+			Value:  fmt.Sprintf("`%s`", created),
+			Inline: true,
+		},
+		{
+			Name:   FieldModifiedOn,
+			Value:  fmt.Sprintf("`%s`", modified),
+			Inline: true,
+		},
+		{
+			Name:   FieldModifiedBy,
+			Value:  fmt.Sprintf("`%s`", notification.ModifiedBy),
+			Inline: true,
+		},
+	}
+	if changes != "" {
+		fields = append(fields, discord.EmbedField{Name: "Spremembe", Value: changes})
+	}
+
+	body := discord.WebhookBody{
 		Username:  "Intranet",
 		AvatarURL: "",
 		Content:   "Novo obvestilo na intranetu",
-		Embeds: []Embed{
+		Embeds: []discord.Embed{
 			{
-				Author: struct {
-					Name    string `json:"name"`
-					URL     string `json:"url"`
-					IconURL string `json:"icon_url"`
-				}{Name: notification.CreatedBy, URL: "", IconURL: ""},
+				Author:      discord.EmbedAuthor{Name: notification.CreatedBy, URL: "", IconURL: ""},
 				Title:       notification.Name,
 				Description: description,
 				Color:       15258703,
-				URL:         fmt.Sprintf("https://gimnazijabezigrad.sharepoint.com/Lists/ObvAkt/DispForm.aspx?ID=%s", notification.ID),
-				Fields: []EmbedField{
-					{
-						Name:   "Ustvarjeno",
-						Value:  fmt.Sprintf("`%s`", created),
-						Inline: true,
-					},
-					{
-						Name:   "Nazadnje spremenjeno",
-						Value:  fmt.Sprintf("`%s`", modified),
-						Inline: true,
-					},
-					{
-						Name:   "Nazadnje spremenil",
-						Value:  fmt.Sprintf("`%s`", notification.ModifiedBy),
-						Inline: true,
-					},
-				},
-				Thumbnail: struct {
-					URL string `json:"url"`
-				}{URL: "https://www.gimb.org/wp-content/uploads/2017/01/logo.png"},
+				URL:         NotificationURL(notification),
+				Fields:      fields,
+				Thumbnail:   discord.EmbedThumbnail{URL: "https://www.gimb.org/wp-content/uploads/2017/01/logo.png"},
 			},
 		},
 	}
+	return body
+}
 
-	request.SetBodyJsonMarshal(body)
+// RenderedHash vrne zgoščeno vrednost sporočila, kot ga pošljemo Discordu.
+// allowed_mentions se razlikuje med objavo in urejanjem, polja o zadnji spremembi pa se
+// spremenijo ob vsakem shranjevanju, zato jih ne upoštevamo.
+func RenderedHash(body discord.WebhookBody) string {
+	body.AllowedMentions = nil
+	embeds := make([]discord.Embed, 0)
+	for _, embed := range body.Embeds {
+		fields := make([]discord.EmbedField, 0)
+		for _, field := range embed.Fields {
+			if field.Name == FieldModifiedOn || field.Name == FieldModifiedBy {
+				continue
+			}
+			fields = append(fields, field)
+		}
+		embed.Fields = fields
+		embeds = append(embeds, embed)
+	}
+	body.Embeds = embeds
+	marshal, _ := json.Marshal(body)
+	sum := sha256.Sum256(marshal)
+	return hex.EncodeToString(sum[:])
+}
 
-	var resp *req.Response
-	var err error
-	if editing {
-		resp, err = request.Patch(webhook)
-	} else {
-		resp, err = request.Post(webhook)
+func (server *httpImpl) SendNotificationToWebhook(ctx context.Context, target config.Target, messageID string, notificationID string, body discord.WebhookBody) (id string, err error) {
+	name := "discord.ExecuteWebhook"
+	if messageID != "" {
+		name = "discord.EditWebhookMessage"
 	}
-	if resp == nil || err != nil {
-		server.logger.Errorw("failure while sending message to discord webhook", "err", err)
-		return ""
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		AttrNotificationID.String(notificationID),
+		AttrTargetID.String(target.ID),
+		AttrMessageID.String(messageID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if messageID != "" {
+		_, err := server.discord.EditWebhookMessage(ctx, target.Webhook, messageID, body)
+		if err != nil {
+			server.logger.Errorw("error while editing message on Discord", "notification", notificationID, "message", messageID, "permanent", discord.IsPermanent(err), "err", err)
+			return "", err
+		}
+		return messageID, nil
 	}
-	server.logger.Infow("Discord responded with status code", "statusCode", resp.StatusCode)
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		server.logger.Errorw("error while sending message to Discord", "body", resp.String())
+
+	message, err := server.discord.ExecuteWebhook(ctx, target.Webhook, body)
+	if err != nil {
+		server.logger.Errorw("error while sending message to Discord", "notification", notificationID, "permanent", discord.IsPermanent(err), "err", err)
+		return "", err
 	}
+	return message.ID, nil
+}
 
-	if editing {
-		return ""
+func (server *httpImpl) DeleteMessageFromWebhook(ctx context.Context, target config.Target, notificationID string, messageID string) (err error) {
+	ctx, span := tracer.Start(ctx, "discord.DeleteWebhookMessage", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		AttrNotificationID.String(notificationID),
+		AttrTargetID.String(target.ID),
+		AttrMessageID.String(messageID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	err = server.discord.DeleteWebhookMessage(ctx, target.Webhook, messageID)
+	// sporočilo je že izbrisano
+	if errors.Is(err, discord.ErrUnknownMessage) {
+		return nil
 	}
+	return err
+}
 
-	var unmarshal DiscordWebhookResponse
-	err = resp.Unmarshal(&unmarshal)
+// ConvertBody pretvori HTML obvestila v markdown, kot ga prikaže Discord.
+func ConvertBody(ctx context.Context, html string) (markdown string, err error) {
+	_, span := tracer.Start(ctx, "ConvertBody", trace.WithAttributes(attribute.Int("html.length", len(html))))
+	defer func() { endSpan(span, err) }()
+
+	opt := &md.Options{}
+	converter := md.NewConverter("", true, opt)
+	markdown, err = converter.ConvertString(html)
 	if err != nil {
-		server.logger.Errorw("could not unmarshal response", "body", resp.String())
-		return ""
+		return "", err
 	}
 
-	return unmarshal.ID
+	// ker discord je pač retarded
+	r := regexp.MustCompile(`\[(?P<URL>.*)]\(.*\)`)
+	res := r.FindAllStringSubmatch(markdown, -1)
+	for _, l := range res {
+		if len(l) < 2 {
+			continue
+		}
+		markdown = strings.ReplaceAll(markdown, l[0], l[1])
+	}
+	return markdown, nil
 }
 
-func (server *httpImpl) GetSharepointNotificationsGoroutine(accessToken string) {
-	server.logger.Infow("getting Sharepoint notifications")
-
-	client := req.C()
+func GraphClient(accessToken string) *req.Client {
+	client := req.C().WrapRoundTripFunc(countGraphRequests)
 
 	client.Headers = make(http.Header)
 	client.Headers.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	nextLink := "https://graph.microsoft.com/v1.0/sites/root/lists/54521912-06dd-4ccc-8edb-8173c9629fd8/items"
-	for nextLink != "" {
-		res, err := client.R().Get(nextLink)
+	return client
+}
+
+// NotificationID vrne ID obvestila v bazi. Obvestila s privzetega seznama ohranijo ID elementa,
+// ostalim pa dodamo ID seznama, saj so ID-ji elementov edinstveni le znotraj seznama.
+func NotificationID(list string, itemID string) string {
+	if list == config.DefaultList {
+		return itemID
+	}
+	return fmt.Sprintf("%s:%s", list, itemID)
+}
+
+// GetSharepointNotificationsGoroutine preveri vse sezname in vrne število novih in posodobljenih obvestil.
+func (server *httpImpl) GetSharepointNotificationsGoroutine(ctx context.Context, accessToken string) (int, error) {
+	server.logger.Infow("getting Sharepoint notifications")
+
+	client := GraphClient(accessToken)
+	processed := 0
+	errs := make([]error, 0)
+	for _, list := range server.config.GetLists() {
+		n, err := server.GetSharepointListNotifications(ctx, client, list)
+		processed += n
 		if err != nil {
-			server.logger.Errorw("error getting all Sharepoint items", "err", err)
-			break
+			errs = append(errs, fmt.Errorf("list %s: %w", list, err))
 		}
+	}
+	return processed, errors.Join(errs...)
+}
 
-		var response SharepointResponse
-		err = res.UnmarshalJson(&response)
+func (server *httpImpl) GetSharepointListNotifications(ctx context.Context, client *req.Client, list string) (processed int, err error) {
+	ctx, span := tracer.Start(ctx, "GetSharepointListNotifications", trace.WithAttributes(AttrListID.String(list)))
+	defer func() {
+		span.SetAttributes(attribute.Int("processed", processed))
+		endSpan(span, err)
+	}()
+
+	errs := make([]error, 0)
+	nextLink := fmt.Sprintf("https://graph.microsoft.com/v1.0/sites/root/lists/%s/items", list)
+	for page := 1; nextLink != ""; page++ {
+		response, err := server.getSharepointPage(ctx, client, list, page, nextLink)
 		if err != nil {
-			server.logger.Errorw("error parsing Microsoft response", "err", err)
-			break
+			return processed, errors.Join(append(errs, err)...)
 		}
 
 		nextLink = response.OdataNextLink
@@ -334,189 +357,420 @@ func (server *httpImpl) GetSharepointNotificationsGoroutine(accessToken string)
 		}
 
 		for _, v := range response.Value {
-			notificationDb, noterr := server.db.GetSharepointNotification(v.Id)
-			if (noterr == nil && notificationDb.ModifiedOn == int(v.LastModifiedDateTime.Unix())) || (noterr != nil && !errors.Is(noterr, sql.ErrNoRows)) {
-				// TODO: preveri, kaj to sranje dela?
-				if err != nil {
-					server.logger.Errorw("error retrieving Sharepoint notification", "id", v.Id, "webUrl", v.WebUrl, "err", err)
-				}
+			id := NotificationID(list, v.Id)
+			notificationDb, noterr := server.db.WithContext(ctx).GetSharepointNotification(id)
+			if noterr != nil && !errors.Is(noterr, sql.ErrNoRows) {
+				server.logger.Errorw("error retrieving Sharepoint notification", "id", v.Id, "webUrl", v.WebUrl, "err", noterr)
+				errs = append(errs, fmt.Errorf("item %s: %w", v.Id, noterr))
 				continue
 			}
-
-			res, err = client.R().Get(fmt.Sprintf("https://graph.microsoft.com/v1.0/sites/root/lists/54521912-06dd-4ccc-8edb-8173c9629fd8/items/%s", v.Id))
-			if err != nil {
-				server.logger.Errorw("error getting a Sharepoint notification", "id", v.Id, "err", err)
-				break
+			if noterr == nil && notificationDb.ModifiedOn == int(v.LastModifiedDateTime.Unix()) {
+				continue
 			}
-
-			var notificationResponse SharepointNotificationResponse
-			err = res.UnmarshalJson(&notificationResponse)
-			if err != nil {
-				server.logger.Errorw("error parsing Sharepoint notification response", "id", v.Id, "err", err)
-				break
+			// obvestila, izbrisana po pravilih hranjenja, znova prenesemo le, če so se na SharePointu spremenila
+			if noterr != nil {
+				pruned, err := server.db.WithContext(ctx).GetPrunedNotification(id)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					server.logger.Errorw("error retrieving pruned Sharepoint notification", "id", v.Id, "err", err)
+					errs = append(errs, fmt.Errorf("item %s: %w", v.Id, err))
+					continue
+				}
+				if err == nil && pruned.ModifiedOn == int(v.LastModifiedDateTime.Unix()) {
+					continue
+				}
 			}
 
-			// ne posodabljaj za vsak drek
-			if noterr == nil && int(notificationResponse.Fields.Modified.Unix()) == notificationDb.ModifiedOn {
+			changed, err := server.processSharepointItem(ctx, client, list, v, notificationDb, noterr == nil)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("item %s: %w", v.Id, err))
+				// ob napaki Grapha (npr. 429 ali 5xx) ga ne obremenjujemo z ostalimi elementi
+				if errors.Is(err, ErrGraphRequest) {
+					return processed, errors.Join(errs...)
+				}
 				continue
 			}
-
-			opt := &md.Options{}
-			converter := md.NewConverter("", true, opt)
-			markdown, err := converter.ConvertString(notificationResponse.Fields.Body)
-			if err != nil {
-				server.logger.Errorw("error parsing Sharepoint HTML", "err", err)
-				break
+			if changed {
+				processed++
 			}
+		}
+	}
+	return processed, errors.Join(errs...)
+}
 
-			// ker discord je pač retarded
-			r := regexp.MustCompile(`\[(?P<URL>.*)]\(.*\)`)
-			res := r.FindAllStringSubmatch(markdown, -1)
-			for _, l := range res {
-				if len(l) < 2 {
-					continue
-				}
-				markdown = strings.ReplaceAll(markdown, l[0], l[1])
-			}
+// ErrGraphRequest pomeni, da zahteva na Microsoft Graph ni uspela ali je vrnila napako.
+var ErrGraphRequest = errors.New("graph request failed")
 
-			notificationResponse.Fields.Body = markdown
+// getSharepointPage prenese eno stran elementov seznama.
+func (server *httpImpl) getSharepointPage(ctx context.Context, client *req.Client, list string, page int, link string) (response SharepointResponse, err error) {
+	ctx, span := tracer.Start(ctx, "graph.GetListItems", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(AttrListID.String(list), AttrPage.Int(page)))
+	defer func() {
+		span.SetAttributes(attribute.Int("items", len(response.Value)))
+		endSpan(span, err)
+	}()
 
-			expires := int(notificationResponse.Fields.Expires.Unix())
-			if expires < 0 {
-				expires = 0
-			}
+	res, err := client.R().SetContext(ctx).Get(link)
+	if err != nil {
+		server.logger.Errorw("error getting all Sharepoint items", "err", err)
+		return response, fmt.Errorf("%w: %w", ErrGraphRequest, err)
+	}
+	span.SetAttributes(AttrStatusCode.Int(res.StatusCode))
+	if !res.IsSuccessState() {
+		server.logger.Errorw("error getting all Sharepoint items", "status", res.StatusCode, "body", res.String())
+		return response, fmt.Errorf("%w: status code %d", ErrGraphRequest, res.StatusCode)
+	}
 
-			if errors.Is(noterr, sql.ErrNoRows) {
-				server.logger.Infow("creating new notification", "id", v.Id)
-
-				not := db.SharepointNotification{
-					ID:             notificationResponse.Id,
-					Name:           notificationResponse.Fields.Title,
-					Description:    notificationResponse.Fields.Body,
-					CreatedOn:      int(notificationResponse.Fields.Created.Unix()),
-					ModifiedOn:     int(notificationResponse.Fields.Modified.Unix()),
-					CreatedBy:      notificationResponse.CreatedBy.User.DisplayName,
-					ModifiedBy:     notificationResponse.LastModifiedBy.User.DisplayName,
-					MessageIDs:     "[]",
-					ExpiresOn:      expires,
-					HasAttachments: notificationResponse.Fields.Attachments,
-				}
+	err = res.UnmarshalJson(&response)
+	if err != nil {
+		server.logger.Errorw("error parsing Microsoft response", "err", err)
+	}
+	return response, err
+}
 
-				ids := make([]string, 0)
-				for _, webhook := range server.config.Webhooks {
-					id := server.SendNotificationToWebhook(webhook, false, not)
-					if id == "" {
-						continue
-					}
-					ids = append(ids, fmt.Sprintf("%s/messages/%s", webhook, id))
-				}
+// processSharepointItem prenese spremenjen element in ga shrani. Vrne true, če je bilo obvestilo
+// ustvarjeno ali posodobljeno. Ob napaki Graph preostalih elementov ne obdelamo, druge napake se zberejo.
+func (server *httpImpl) processSharepointItem(ctx context.Context, client *req.Client, list string, v SharepointItem, notificationDb db.SharepointNotification, exists bool) (changed bool, err error) {
+	id := NotificationID(list, v.Id)
+	ctx, span := tracer.Start(ctx, "processSharepointItem", trace.WithAttributes(AttrNotificationID.String(id), AttrListID.String(list)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("changed", changed))
+		endSpan(span, err)
+	}()
+	database := server.db.WithContext(ctx)
+
+	res, err := client.R().SetContext(ctx).Get(fmt.Sprintf("https://graph.microsoft.com/v1.0/sites/root/lists/%s/items/%s", list, v.Id))
+	if err != nil {
+		server.logger.Errorw("error getting a Sharepoint notification", "id", v.Id, "err", err)
+		return false, fmt.Errorf("%w: %w", ErrGraphRequest, err)
+	}
+	// telo napake (npr. 429 ali 5xx) bi se razčlenilo v prazno obvestilo in izbrisalo vsebino sporočil
+	if !res.IsSuccessState() {
+		server.logger.Errorw("error getting a Sharepoint notification", "id", v.Id, "status", res.StatusCode, "body", res.String())
+		return false, fmt.Errorf("%w: status code %d", ErrGraphRequest, res.StatusCode)
+	}
 
-				marshal, err := json.Marshal(ids)
-				if err != nil {
-					server.logger.Errorw("error while marshalling message IDs", "err", err)
-					continue
-				}
-				not.MessageIDs = string(marshal)
+	var notificationResponse SharepointNotificationResponse
+	err = res.UnmarshalJson(&notificationResponse)
+	if err != nil {
+		server.logger.Errorw("error parsing Sharepoint notification response", "id", v.Id, "err", err)
+		return false, err
+	}
 
-				err = server.db.InsertSharepointNotification(not)
-				if err != nil {
-					server.logger.Errorw("error inserting Sharepoint notification", "id", v.Id, "notification", notificationResponse, "not", not, "err", err)
-					continue
-				}
-			} else {
-				server.logger.Infow("updating an existing notification", "id", v.Id)
+	// vsi stolpci, tudi tisti po meri, za pravila usmerjanja
+	var rawFields struct {
+		Fields map[string]any `json:"fields"`
+	}
+	err = res.UnmarshalJson(&rawFields)
+	if err != nil {
+		server.logger.Errorw("error parsing Sharepoint notification fields", "id", v.Id, "err", err)
+		return false, err
+	}
+	fields, err := json.Marshal(rawFields.Fields)
+	if err != nil {
+		server.logger.Errorw("error marshalling Sharepoint notification fields", "id", v.Id, "err", err)
+		return false, err
+	}
 
-				notificationDb.ModifiedOn = int(notificationResponse.Fields.Modified.Unix())
-				notificationDb.ModifiedBy = notificationResponse.LastModifiedBy.User.DisplayName
-				notificationDb.ExpiresOn = expires
-				notificationDb.Name = notificationResponse.Fields.Title
-				notificationDb.Description = notificationResponse.Fields.Body
-				notificationDb.HasAttachments = notificationResponse.Fields.Attachments
+	// ne posodabljaj za vsak drek
+	if exists && int(notificationResponse.Fields.Modified.Unix()) == notificationDb.ModifiedOn {
+		return false, nil
+	}
 
-				err := server.db.UpdateSharepointNotification(notificationDb)
-				if err != nil {
-					server.logger.Errorw("error updating Sharepoint notification", "id", v.Id, "notification", notificationResponse, "not", notificationDb, "err", err)
-					continue
-				}
+	html := notificationResponse.Fields.Body
+
+	markdown, err := ConvertBody(ctx, html)
+	if err != nil {
+		server.logger.Errorw("error parsing Sharepoint HTML", "err", err)
+		return false, err
+	}
+
+	notificationResponse.Fields.Body = markdown
+
+	expires := int(notificationResponse.Fields.Expires.Unix())
+	if expires < 0 {
+		expires = 0
+	}
+
+	// obvestila, ki smo jih izbrisali po pravilih hranjenja, so lahko še vedno na SharePointu
+	if !exists && server.Prunable(expires, time.Now()) {
+		err = database.UpsertPrunedNotification(db.PrunedNotification{
+			ID:         id,
+			ModifiedOn: int(notificationResponse.Fields.Modified.Unix()),
+			CreatedOn:  int(time.Now().Unix()),
+		})
+		if err != nil {
+			server.logger.Errorw("error recording pruned Sharepoint notification", "id", v.Id, "err", err)
+		}
+		return false, err
+	}
+
+	version := notificationResponse.Fields.UIVersionString
+	if version == "" {
+		version = strconv.FormatInt(notificationResponse.Fields.Modified.Unix(), 10)
+	}
+	_, err = database.InsertNotificationRevision(db.NotificationRevision{
+		NotificationID: id,
+		Version:        version,
+		Title:          notificationResponse.Fields.Title,
+		HTML:           html,
+		Markdown:       markdown,
+		ModifiedBy:     notificationResponse.LastModifiedBy.User.DisplayName,
+		ModifiedOn:     int(notificationResponse.Fields.Modified.Unix()),
+		ETag:           notificationResponse.ETag,
+		CreatedOn:      int(time.Now().Unix()),
+	})
+	if err != nil {
+		server.logger.Errorw("error inserting notification revision", "id", v.Id, "version", version, "err", err)
+	}
+
+	// različice, ki so nastale med dvema preverjanjema ali pred prvim preverjanjem novega obvestila
+	_, err = server.ImportSharepointVersions(ctx, client, list, v.Id)
+	if err != nil {
+		server.logger.Errorw("error importing Sharepoint versions", "id", v.Id, "err", err)
+	}
+
+	if !exists {
+		server.logger.Infow("creating new notification", "id", v.Id)
+
+		not := db.SharepointNotification{
+			ID:             id,
+			Name:           notificationResponse.Fields.Title,
+			Description:    notificationResponse.Fields.Body,
+			CreatedOn:      int(notificationResponse.Fields.Created.Unix()),
+			ModifiedOn:     int(notificationResponse.Fields.Modified.Unix()),
+			CreatedBy:      notificationResponse.CreatedBy.User.DisplayName,
+			ModifiedBy:     notificationResponse.LastModifiedBy.User.DisplayName,
+			ExpiresOn:      expires,
+			HasAttachments: notificationResponse.Fields.Attachments,
+			ListID:         list,
+			ContentType:    notificationResponse.ContentType.Name,
+			Fields:         string(fields),
+			WebURL:         notificationResponse.WebUrl,
+			UpdatedOn:      int(time.Now().Unix()),
+		}
 
-				var unmarshal []string
-				err = json.Unmarshal([]byte(notificationDb.MessageIDs), &unmarshal)
+		// obvestilo in namen dostave se zapišeta skupaj, da ob sesutju nobeno obvestilo ne ostane neobjavljeno
+		err = server.db.WithTx(ctx, func(tx db.SQL) error {
+			err := tx.InsertSharepointNotification(not)
+			if err != nil {
+				return err
+			}
+			// obvestilo, ki je bilo izbrisano, a so mu na SharePointu podaljšali veljavnost
+			err = tx.DeletePrunedNotification(not.ID)
+			if err != nil {
+				return err
+			}
+			for _, target := range server.MessageTargets(not) {
+				err = tx.InsertOutboxEntry(NewOutboxEntry(not.ID, target.ID, db.OutboxActionPost, "", time.Now()))
 				if err != nil {
-					server.logger.Errorw("error unmarshalling message IDs", "err", err)
-					continue
+					return err
 				}
+			}
+			return nil
+		})
+		if err != nil {
+			server.logger.Errorw("error inserting Sharepoint notification", "id", v.Id, "notification", notificationResponse, "not", not, "err", err)
+			return false, err
+		}
+		NotificationChanges.WithLabelValues("created").Inc()
+		return true, nil
+	}
 
-				for _, webhook := range unmarshal {
-					server.SendNotificationToWebhook(webhook, true, notificationDb)
-				}
+	server.logger.Infow("updating an existing notification", "id", v.Id)
+
+	previous := notificationDb
+
+	// prejšnjo različico shranimo le ob vsebinski spremembi, da je ne izgubimo ob shranjevanju brez sprememb
+	if notificationDb.Name != notificationResponse.Fields.Title || notificationDb.Description != notificationResponse.Fields.Body || notificationDb.ExpiresOn != expires {
+		notificationDb.PreviousName = notificationDb.Name
+		notificationDb.PreviousDescription = notificationDb.Description
+		notificationDb.PreviousExpiresOn = notificationDb.ExpiresOn
+	}
+	notificationDb.ModifiedOn = int(notificationResponse.Fields.Modified.Unix())
+	notificationDb.ModifiedBy = notificationResponse.LastModifiedBy.User.DisplayName
+	notificationDb.ExpiresOn = expires
+	notificationDb.Name = notificationResponse.Fields.Title
+	notificationDb.Description = notificationResponse.Fields.Body
+	notificationDb.HasAttachments = notificationResponse.Fields.Attachments
+	notificationDb.ContentType = notificationResponse.ContentType.Name
+	notificationDb.Fields = string(fields)
+	notificationDb.WebURL = notificationResponse.WebUrl
+	notificationDb.UpdatedOn = int(time.Now().Unix())
+
+	// razliko izračunamo le za cilje s ponovno objavo in največ enkrat
+	var substantial *bool
+	isSubstantial := func() bool {
+		if substantial == nil {
+			s := IsSubstantialChange(previous.Name, previous.Description, notificationDb.Name, notificationDb.Description)
+			substantial = &s
+		}
+		return *substantial
+	}
+	err = server.db.WithTx(ctx, func(tx db.SQL) error {
+		err := tx.UpdateSharepointNotification(notificationDb)
+		if err != nil {
+			return err
+		}
+		deliveries, err := tx.GetDeliveriesForNotification(notificationDb.ID)
+		if err != nil {
+			return err
+		}
+		for _, delivery := range deliveries {
+			if delivery.Status != db.DeliveryStatusPosted {
+				continue
+			}
+			entry := NewOutboxEntry(notificationDb.ID, delivery.TargetID, db.OutboxActionEdit, delivery.MessageID, time.Now())
+			target, ok := server.config.GetTarget(delivery.TargetID)
+			if ok && target.EditPolicy == config.EditPolicyRepost && isSubstantial() {
+				summary := DiffSummary(previous.Name, previous.Description, notificationDb.Name, notificationDb.Description)
+				entry = NewRepostEntry(notificationDb.ID, delivery.TargetID, delivery.MessageID, summary)
+			}
+			err = tx.InsertOutboxEntry(entry)
+			if err != nil {
+				return err
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		server.logger.Errorw("error updating Sharepoint notification", "id", v.Id, "notification", notificationResponse, "not", notificationDb, "err", err)
+		return false, err
 	}
+	NotificationChanges.WithLabelValues("updated").Inc()
+	return true, nil
 }
 
-func (server *httpImpl) SharepointGoroutine() {
+func (server *httpImpl) SharepointGoroutine(ctx context.Context) error {
 	server.logger.Infow("starting Sharepoint goroutine")
 
-	for {
-		if server.config.MicrosoftOAUTH2RefreshToken == "" {
+	failures := 0
+	retry := func(msg string, err error) {
+		failures++
+		backoff := Backoff(failures, SupervisorBaseBackoff, SupervisorMaxBackoff)
+		server.logger.Errorw(msg, "attempts", failures, "backoff", backoff, "err", err)
+		server.status.recordNextSync(time.Now().Add(backoff))
+		server.waitUntil(ctx, time.Now().Add(backoff))
+	}
+
+	for ctx.Err() == nil {
+		if server.status.getRefreshToken() == "" {
 			server.logger.Infow("no Microsoft OAUTH2 refresh token was found")
 			server.MicrosoftOAUTH2URL()
-			server.MicrosoftOAUTH2Callback()
-			return // konča gorutino, avtomatično znova zažene program
-		}
-
-		client := req.C()
-
-		body := map[string]string{
-			"client_id":     server.config.MicrosoftOAUTH2ClientID,
-			"client_secret": server.config.MicrosoftOAUTH2Secret,
-			"refresh_token": server.config.MicrosoftOAUTH2RefreshToken,
-			"scope":         SCOPE,
-			"grant_type":    "refresh_token",
+			err := server.MicrosoftOAUTH2Callback(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					retry("error authorizing with Microsoft, retrying", err)
+				}
+				continue
+			}
 		}
 
-		res, err := client.R().SetFormData(body).Post("https://login.microsoftonline.com/organizations/oauth2/v2.0/token")
+		syncCtx, span := tracer.Start(ctx, "SharepointSync")
+		// osveževanja ne prekinemo ob zaustavitvi, da ne izgubimo novega žetona za osveževanje
+		accessToken, err := server.RefreshAccessToken(context.WithoutCancel(syncCtx))
 		if err != nil {
-			server.logger.Errorw("error getting token", "err", err)
-			break
+			TokenRefreshFailures.Inc()
+			endSpan(span, err)
+			retry("error refreshing token, retrying", err)
+			continue
 		}
+		failures = 0
 
-		var response MicrosoftOUATH2Response
-		err = res.UnmarshalJson(&response)
+		start := time.Now()
+		processed, err := server.GetSharepointNotificationsGoroutine(syncCtx, accessToken)
+		server.status.recordSync(processed, err)
 		if err != nil {
-			server.logger.Errorw("error parsing Microsoft response", "err", err)
-			break
+			SyncDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+			server.logger.Errorw("error getting Sharepoint notifications", "err", err)
+		} else {
+			SyncDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
 		}
+		span.SetAttributes(attribute.Int("processed", processed))
+		endSpan(span, err)
 
-		accessToken := response.AccessToken
-		refreshToken := response.RefreshToken
+		server.logger.Infow("ran Sharepoint goroutine", "processed", processed)
+		server.waitForSync(ctx)
+	}
 
-		server.config.MicrosoftOAUTH2RefreshToken = refreshToken
-		err = config.SaveConfig(server.config)
-		if err != nil {
-			server.logger.Errorw("error saving config", "err", err)
-			break
-		}
+	server.logger.Infow("exiting Sharepoint goroutine")
+	return nil
+}
 
-		server.GetSharepointNotificationsGoroutine(accessToken)
+// RefreshAccessToken pridobi nov dostopni žeton in shrani novi žeton za osveževanje.
+func (server *httpImpl) RefreshAccessToken(ctx context.Context) (accessToken string, err error) {
+	ctx, span := tracer.Start(ctx, "RefreshAccessToken", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
 
-		server.logger.Infow("ran Sharepoint goroutine")
-		time.Sleep(time.Hour)
+	client := req.C()
+
+	body := map[string]string{
+		"client_id":     server.config.MicrosoftOAUTH2ClientID,
+		"client_secret": server.config.MicrosoftOAUTH2Secret,
+		"refresh_token": server.status.getRefreshToken(),
+		"scope":         SCOPE,
+		"grant_type":    "refresh_token",
 	}
 
-	server.logger.Infow("exiting Sharepoint goroutine")
+	res, err := client.R().SetContext(ctx).SetFormData(body).Post("https://login.microsoftonline.com/organizations/oauth2/v2.0/token")
+	if err != nil {
+		err = fmt.Errorf("error getting token: %w", err)
+		server.status.recordToken(0, err)
+		return "", err
+	}
+
+	var response MicrosoftOUATH2Response
+	err = res.UnmarshalJson(&response)
+	if err != nil {
+		err = fmt.Errorf("error parsing Microsoft response: %w", err)
+		server.status.recordToken(0, err)
+		return "", err
+	}
+	if response.AccessToken == "" {
+		err = fmt.Errorf("microsoft did not return an access token: %s", res.String())
+		server.status.recordToken(0, err)
+		return "", err
+	}
+	server.status.recordToken(response.ExpiresIn, nil)
+	if response.RefreshToken == "" {
+		return response.AccessToken, nil
+	}
+
+	server.status.recordRefreshToken(response.RefreshToken)
+	server.config.MicrosoftOAUTH2RefreshToken = response.RefreshToken
+	err = config.SaveConfig(server.config)
+	if err != nil {
+		return "", fmt.Errorf("error saving config: %w", err)
+	}
+
+	return response.AccessToken, nil
 }
 
 func (server *httpImpl) MicrosoftOAUTH2URL() {
 	fmt.Printf("Obiščite stran in avtorizirajte session: https://login.microsoftonline.com/organizations/oauth2/v2.0/authorize?client_id=%s&response_type=code&response_mode=query&scope=offline_access %s\n", server.config.MicrosoftOAUTH2ClientID, SCOPE)
 }
 
-func (server *httpImpl) MicrosoftOAUTH2Callback() {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Enter Microsoft code: ")
-	code, err := reader.ReadString('\n')
-	if err != nil {
-		server.logger.Fatalw("error reading input", "err", err)
+// MicrosoftOAUTH2Callback prebere kodo z vhoda in jo zamenja za žeton za osveževanje.
+func (server *httpImpl) MicrosoftOAUTH2Callback(ctx context.Context) error {
+	type input struct {
+		code string
+		err  error
+	}
+	read := make(chan input, 1)
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print("Enter Microsoft code: ")
+		code, err := reader.ReadString('\n')
+		read <- input{code, err}
+	}()
+
+	var code string
+	select {
+	case in := <-read:
+		if in.err != nil {
+			return fmt.Errorf("error reading input: %w", in.err)
+		}
+		code = strings.TrimSpace(in.code)
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
 	client := req.C()
@@ -529,25 +783,27 @@ func (server *httpImpl) MicrosoftOAUTH2Callback() {
 		"grant_type":    "authorization_code",
 	}
 
-	res, err := client.R().SetFormData(body).Post("https://login.microsoftonline.com/organizations/oauth2/v2.0/token")
+	res, err := client.R().SetContext(ctx).SetFormData(body).Post("https://login.microsoftonline.com/organizations/oauth2/v2.0/token")
 	if err != nil {
-		server.logger.Fatalw("error getting token", "err", err)
-		return
+		return fmt.Errorf("error getting token: %w", err)
 	}
 
 	var response MicrosoftOUATH2Response
 	err = res.UnmarshalJson(&response)
 	if err != nil {
-		server.logger.Fatalw("error unmarshalling token", "err", err)
-		return
+		return fmt.Errorf("error unmarshalling token: %w", err)
+	}
+	if response.RefreshToken == "" {
+		return fmt.Errorf("microsoft did not return a refresh token: %s", res.String())
 	}
 
+	server.status.recordRefreshToken(response.RefreshToken)
 	server.config.MicrosoftOAUTH2RefreshToken = response.RefreshToken
 	err = config.SaveConfig(server.config)
 	if err != nil {
-		server.logger.Fatalw("error saving token", "err", err)
-		return
+		return fmt.Errorf("error saving token: %w", err)
 	}
 
 	server.logger.Infow("token received successfully")
+	return nil
 }
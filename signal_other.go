@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "context"
+
+// TriggerSyncOnSignal na sistemih brez SIGUSR1 ne naredi ničesar, preverjanje se sproži prek /admin/sync.
+func (server *httpImpl) TriggerSyncOnSignal(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// TriggerSyncOnSignal ob SIGUSR1 (npr. docker kill -s USR1) sproži takojšnje preverjanje.
+func (server *httpImpl) TriggerSyncOnSignal(ctx context.Context) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-signals:
+			server.TriggerSync("signal")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"SharepointBot/db"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SyncStatus je stanje zadnjega preverjanja SharePointa in žetona za /status in /readyz.
+type SyncStatus struct {
+	mu             sync.Mutex
+	LastSync       time.Time `json:"last_sync"`
+	LastSuccess    time.Time `json:"last_success"`
+	LastError      string    `json:"last_error"`
+	Processed      int       `json:"processed"`
+	TotalProcessed int       `json:"total_processed"`
+	TokenExpiresOn time.Time `json:"token_expires_on"`
+	TokenError     string    `json:"token_error"`
+	NextSync       time.Time `json:"next_sync"`
+	HasToken       bool      `json:"has_refresh_token"`
+	// žeton za osveževanje hranimo tu, da ga HTTP strežnik ne bere iz konfiguracije med pisanjem
+	refreshToken string
+}
+
+func (status *SyncStatus) recordSync(processed int, err error) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.LastSync = time.Now()
+	status.Processed = processed
+	status.TotalProcessed += processed
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+		return
+	}
+	status.LastSuccess = status.LastSync
+}
+
+func (status *SyncStatus) recordToken(expiresIn int, err error) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.TokenError = ""
+	if err != nil {
+		status.TokenError = err.Error()
+		return
+	}
+	status.TokenExpiresOn = time.Now().Add(time.Duration(expiresIn) * time.Second)
+}
+
+func (status *SyncStatus) recordRefreshToken(token string) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.refreshToken = token
+	status.HasToken = token != ""
+}
+
+func (status *SyncStatus) getRefreshToken() string {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	return status.refreshToken
+}
+
+func (status *SyncStatus) recordNextSync(next time.Time) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.NextSync = next
+}
+
+func (status *SyncStatus) snapshot() SyncStatus {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	return SyncStatus{
+		LastSync:       status.LastSync,
+		LastSuccess:    status.LastSuccess,
+		LastError:      status.LastError,
+		Processed:      status.Processed,
+		TotalProcessed: status.TotalProcessed,
+		TokenExpiresOn: status.TokenExpiresOn,
+		TokenError:     status.TokenError,
+		NextSync:       status.NextSync,
+		HasToken:       status.HasToken,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// readiness vrne neuspešna preverjanja pripravljenosti, ključ je ime preverjanja.
+func (server *httpImpl) readiness() map[string]string {
+	failed := make(map[string]string)
+	status := server.status.snapshot()
+
+	err := server.db.Ping()
+	if err != nil {
+		failed["database"] = err.Error()
+	}
+
+	// dostopni žeton se osveži šele ob naslednjem preverjanju, zato njegov potek ne pomeni nepripravljenosti
+	switch {
+	case !status.HasToken:
+		failed["token"] = "no Microsoft OAUTH2 refresh token"
+	case status.TokenError != "":
+		failed["token"] = status.TokenError
+	}
+
+	maxAge := time.Duration(server.config.SyncMaxAgeMinutes) * time.Minute
+	switch {
+	case status.LastSuccess.IsZero():
+		failed["sync"] = "no successful sync yet"
+	case time.Since(status.LastSuccess) > maxAge:
+		failed["sync"] = fmt.Sprintf("last successful sync %s ago: %s", time.Since(status.LastSuccess).Round(time.Second), status.LastError)
+	}
+	return failed
+}
+
+func (server *httpImpl) statusHandler(w http.ResponseWriter, r *http.Request) {
+	pending, err := server.db.WithContext(r.Context()).GetOutboxEntriesByStatus(db.OutboxStatusPending)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	dead, err := server.db.WithContext(r.Context()).GetOutboxEntriesByStatus(db.OutboxStatusDead)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	uncertain, err := server.db.WithContext(r.Context()).GetOutboxEntriesByStatus(db.OutboxStatusUncertain)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sync":                 server.status.snapshot(),
+		"pending_deliveries":   len(pending),
+		"dead_deliveries":      len(dead),
+		"uncertain_deliveries": len(uncertain),
+		"ready":                len(server.readiness()) == 0,
+	})
+}
+
+func (server *httpImpl) searchHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	results, err := server.db.WithContext(r.Context()).SearchSharepointNotifications(r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// authorizeAdmin preveri žeton za /admin/*, /status in /search. Brez nastavljenega žetona sprejme le lokalne zahteve.
+func (server *httpImpl) authorizeAdmin(r *http.Request) bool {
+	if server.config.AdminToken != "" {
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+server.config.AdminToken)) == 1
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// admin zavrne zahteve, ki jih authorizeAdmin ne dovoli.
+func (server *httpImpl) admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !server.authorizeAdmin(r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (server *httpImpl) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		failed := server.readiness()
+		if len(failed) != 0 {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "not ready", "failed": failed})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+	mux.HandleFunc("GET /status", server.admin(server.statusHandler))
+	mux.HandleFunc("GET /search", server.admin(server.searchHandler))
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("POST /admin/sync", server.admin(func(w http.ResponseWriter, r *http.Request) {
+		server.TriggerSync("http")
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "sync triggered"})
+	}))
+	return mux
+}
+
+func (server *httpImpl) HTTPServer(ctx context.Context) error {
+	server.logger.Infow("starting HTTP server", "addr", server.config.HTTPAddr)
+	s := &http.Server{
+		Addr:              server.config.HTTPAddr,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		server.logger.Infow("stopping HTTP server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := s.Shutdown(shutdownCtx)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// localURL vrne naslov poti na HTTP strežniku delujočega procesa na istem računalniku.
+func localURL(cfg config.Config, path string) (string, error) {
+	host, port, err := net.SplitHostPort(cfg.HTTPAddr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(host, port), path), nil
+}
+
+// Healthcheck preveri /readyz (ali /healthz, če je check live) delujočega procesa, npr. za HEALTHCHECK v Dockerju.
+func Healthcheck(cfg config.Config, check string) error {
+	path := "/readyz"
+	if check == "live" {
+		path = "/healthz"
+	}
+	url, err := localURL(cfg, path)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var body map[string]any
+	_ = json.NewDecoder(res.Body).Decode(&body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status code %d: %v", path, res.StatusCode, body)
+	}
+	return nil
+}
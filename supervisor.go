@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var (
+	SupervisorBaseBackoff = 30 * time.Second
+	SupervisorMaxBackoff  = 15 * time.Minute
+)
+
+var ErrGoroutineExited = errors.New("goroutine exited")
+
+// Backoff vrne eksponentni zamik za podano število neuspelih poskusov, omejen z max.
+func Backoff(attempts int, base time.Duration, max time.Duration) time.Duration {
+	backoff := base
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= max {
+			return max
+		}
+	}
+	return backoff
+}
+
+// sleep počaka d ali do preklica ctx. Vrne false, če je bil ctx preklican.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Supervisor poganja gorutine do preklica korenskega konteksta. Gorutino, ki se konča z napako ali
+// panicom, znova zažene z eksponentnim zamikom, ob zaustavitvi pa počaka, da vse dokončajo začeto delo.
+type Supervisor struct {
+	logger *zap.SugaredLogger
+	wg     sync.WaitGroup
+}
+
+func NewSupervisor(logger *zap.SugaredLogger) *Supervisor {
+	return &Supervisor{logger: logger}
+}
+
+func (s *Supervisor) Go(ctx context.Context, name string, run func(ctx context.Context) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		failures := 0
+		for {
+			start := time.Now()
+			err := s.run(ctx, name, run)
+			if ctx.Err() != nil {
+				return
+			}
+			// gorutina, ki je dolgo delovala, ne podaljšuje zamika
+			if time.Since(start) > SupervisorMaxBackoff {
+				failures = 0
+			}
+			failures++
+			backoff := Backoff(failures, SupervisorBaseBackoff, SupervisorMaxBackoff)
+			GoroutineRestarts.WithLabelValues(name).Inc()
+			s.logger.Errorw("goroutine stopped, restarting", "goroutine", name, "attempts", failures, "backoff", backoff, "err", err)
+			if !sleep(ctx, backoff) {
+				return
+			}
+		}
+	}()
+}
+
+func (s *Supervisor) run(ctx context.Context, name string, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Errorw("goroutine panicked", "goroutine", name, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	err = run(ctx)
+	if err == nil {
+		err = ErrGoroutineExited
+	}
+	return err
+}
+
+// Wait počaka, da se vse gorutine končajo.
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"SharepointBot/db"
+	"slices"
+	"time"
+)
+
+// SyncTargets uskladi dostave s cilji v konfiguraciji. Novim ciljem po želji objavi še veljavna
+// obvestila, dostave za odstranjene cilje pa odklopi, da jih ne urejamo več.
+func (server *httpImpl) SyncTargets() {
+	known, err := server.db.GetDeliveryTargetIDs()
+	if err != nil {
+		server.logger.Errorw("error retrieving delivery targets", "err", err)
+		return
+	}
+
+	configured := make([]string, 0)
+	for _, target := range server.config.GetTargets() {
+		configured = append(configured, target.ID)
+
+		if target.IsDigest() {
+			continue
+		}
+
+		if slices.Contains(known, target.ID) {
+			err = server.db.UpdateDeliveriesStatusForTarget(target.ID, db.DeliveryStatusDetached, db.DeliveryStatusPosted)
+			if err != nil {
+				server.logger.Errorw("error reattaching deliveries", "target", target.ID, "err", err)
+			}
+			continue
+		}
+
+		entries, err := server.db.GetOutboxEntriesForTarget(target.ID)
+		if err != nil {
+			server.logger.Errorw("error retrieving outbox entries", "target", target.ID, "err", err)
+			continue
+		}
+		if len(entries) != 0 {
+			continue
+		}
+
+		if !target.Backfill {
+			server.logger.Infow("new target has no deliveries, backfill is disabled", "target", target.ID)
+			continue
+		}
+
+		now := time.Now()
+		notifications, err := server.db.GetActiveSharepointNotifications(int(now.Unix()))
+		if err != nil {
+			server.logger.Errorw("error retrieving active notifications", "err", err)
+			continue
+		}
+		server.logger.Infow("backfilling new target", "target", target.ID, "notifications", len(notifications))
+		// obvestila razporedimo po sekundah, da ohranimo kronološki vrstni red
+		i := 0
+		for _, notification := range notifications {
+			if !server.routedToTarget(notification, target) {
+				continue
+			}
+			server.EnqueueDeliveryAt(notification.ID, target.ID, db.OutboxActionPost, "", now.Add(time.Duration(i)*time.Second))
+			i++
+		}
+	}
+
+	for _, targetID := range known {
+		if slices.Contains(configured, targetID) {
+			continue
+		}
+		server.logger.Infow("target was removed from config, detaching its deliveries", "target", targetID)
+		err = server.db.UpdateDeliveriesStatusForTarget(targetID, db.DeliveryStatusPosted, db.DeliveryStatusDetached)
+		if err != nil {
+			server.logger.Errorw("error detaching deliveries", "target", targetID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"os"
+)
+
+// tracer uporablja globalnega ponudnika, zato deluje tudi, če ga InitTracing nastavi šele kasneje.
+var tracer = otel.Tracer("SharepointBot")
+
+const (
+	AttrNotificationID = attribute.Key("notification.id")
+	AttrTargetID       = attribute.Key("target.id")
+	AttrListID         = attribute.Key("sharepoint.list.id")
+	AttrOutboxID       = attribute.Key("outbox.id")
+	AttrOutboxAction   = attribute.Key("outbox.action")
+	AttrMessageID      = attribute.Key("discord.message.id")
+	AttrPage           = attribute.Key("graph.page")
+	AttrStatusCode     = attribute.Key("http.response.status_code")
+)
+
+// InitTracing nastavi izvoz sledi v zbiralnik OTLP. Vrnjena funkcija ob izhodu izvozi še neposlane sledi.
+func InitTracing(cfg config.Tracing, logger *zap.SugaredLogger) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := make([]otlptracehttp.Option, 0)
+	if cfg.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "SharepointBot")))
+	if err != nil {
+		return nil, err
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warnw("error exporting traces", "err", err)
+	}))
+
+	logger.Infow("exporting traces", "endpoint", cfg.Endpoint, "sample_ratio", cfg.SampleRatio)
+	return provider.Shutdown, nil
+}
+
+// endSpan zaključi span in ga označi kot neuspešnega, če je prišlo do napake.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
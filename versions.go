@@ -0,0 +1,123 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"SharepointBot/db"
+	"context"
+	"fmt"
+	"github.com/imroc/req/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+type SharepointVersionsResponse struct {
+	OdataContext  string `json:"@odata.context"`
+	OdataNextLink string `json:"@odata.nextLink"`
+	Value         []struct {
+		Id                   string    `json:"id"`
+		LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+		LastModifiedBy       struct {
+			User struct {
+				Email       string `json:"email"`
+				Id          string `json:"id"`
+				DisplayName string `json:"displayName"`
+			} `json:"user"`
+		} `json:"lastModifiedBy"`
+		Fields struct {
+			OdataEtag string `json:"@odata.etag"`
+			Title     string `json:"Title"`
+			Body      string `json:"Body"`
+		} `json:"fields"`
+	} `json:"value"`
+}
+
+// SplitNotificationID vrne seznam in ID elementa za ID obvestila (obratno od NotificationID).
+func SplitNotificationID(notification db.SharepointNotification) (string, string) {
+	list, itemID, found := strings.Cut(notification.ID, ":")
+	if !found {
+		list = notification.ListID
+		if list == "" {
+			list = config.DefaultList
+		}
+		return list, notification.ID
+	}
+	return list, itemID
+}
+
+// ImportSharepointVersions prenese zgodovino različic elementa, da ne izgubimo sprememb med dvema preverjanjema.
+func (server *httpImpl) ImportSharepointVersions(ctx context.Context, client *req.Client, list string, itemID string) (imported int, err error) {
+	id := NotificationID(list, itemID)
+	ctx, span := tracer.Start(ctx, "ImportSharepointVersions", trace.WithAttributes(AttrNotificationID.String(id), AttrListID.String(list)))
+	defer func() {
+		span.SetAttributes(attribute.Int("imported", imported))
+		endSpan(span, err)
+	}()
+
+	seen := 0
+	nextLink := fmt.Sprintf("https://graph.microsoft.com/v1.0/sites/root/lists/%s/items/%s/versions?$expand=fields", list, itemID)
+	for nextLink != "" {
+		res, err := client.R().SetContext(ctx).Get(nextLink)
+		if err != nil {
+			return imported, err
+		}
+		if !res.IsSuccessState() {
+			return imported, fmt.Errorf("graph responded with status code %d: %s", res.StatusCode, res.String())
+		}
+
+		var response SharepointVersionsResponse
+		err = res.UnmarshalJson(&response)
+		if err != nil {
+			return imported, err
+		}
+		nextLink = response.OdataNextLink
+
+		for _, version := range response.Value {
+			// Graph vrne najnovejše različice najprej, starejših od hranjenih ne uvažamo znova
+			if keep := server.config.Retention.Revisions; keep > 0 && seen >= keep {
+				return imported, nil
+			}
+			seen++
+			markdown, err := ConvertBody(ctx, version.Fields.Body)
+			if err != nil {
+				return imported, err
+			}
+			inserted, err := server.db.WithContext(ctx).InsertNotificationRevision(db.NotificationRevision{
+				NotificationID: id,
+				Version:        version.Id,
+				Title:          version.Fields.Title,
+				HTML:           version.Fields.Body,
+				Markdown:       markdown,
+				ModifiedBy:     version.LastModifiedBy.User.DisplayName,
+				ModifiedOn:     int(version.LastModifiedDateTime.Unix()),
+				ETag:           version.Fields.OdataEtag,
+				CreatedOn:      int(time.Now().Unix()),
+			})
+			if err != nil {
+				return imported, err
+			}
+			if inserted {
+				imported++
+			}
+		}
+	}
+
+	return imported, nil
+}
+
+// ImportAllSharepointVersions prenese zgodovino različic za vsa shranjena obvestila.
+func (server *httpImpl) ImportAllSharepointVersions(ctx context.Context, client *req.Client) error {
+	notifications, err := server.db.GetSharepointNotifications()
+	if err != nil {
+		return err
+	}
+	for _, notification := range notifications {
+		list, itemID := SplitNotificationID(notification)
+		_, err = server.ImportSharepointVersions(ctx, client, list, itemID)
+		if err != nil {
+			server.logger.Errorw("error importing Sharepoint versions", "id", notification.ID, "err", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"fmt"
+	"time"
+)
+
+// clock je ura v dnevu po lokalnem času. Ob prehodu na poletni čas dan nima 24 ur, zato ure ne
+// prištevamo polnoči, ampak jo postavimo s time.Date.
+type clock struct {
+	hour   int
+	minute int
+}
+
+func parseClock(value string) (clock, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return clock{}, fmt.Errorf("invalid time %q: %w", value, err)
+	}
+	return clock{t.Hour(), t.Minute()}, nil
+}
+
+// on vrne trenutek ob uri na dan day (lahko tudi izven meseca, npr. day+1) v časovnem pasu location.
+func (c clock) on(year int, month time.Month, day int, location *time.Location) time.Time {
+	return time.Date(year, month, day, c.hour, c.minute, 0, 0, location)
+}
+
+func (c clock) minutes() int {
+	return c.hour*60 + c.minute
+}
+
+// NextWindowStart vrne trenutek, ko se lahko cilju objavi novo obvestilo. Če smo znotraj okna, vrne now.
+func NextWindowStart(window *config.Window, location *time.Location, now time.Time) (time.Time, error) {
+	if window == nil {
+		return now, nil
+	}
+	start, err := parseClock(window.Start)
+	if err != nil {
+		return now, err
+	}
+	end, err := parseClock(window.End)
+	if err != nil {
+		return now, err
+	}
+	// okno brez trajanja je vedno odprto
+	if start == end {
+		return now, nil
+	}
+
+	local := now.In(location)
+	current := clock{local.Hour(), local.Minute()}.minutes()
+
+	var inside bool
+	if start.minutes() <= end.minutes() {
+		inside = current >= start.minutes() && current < end.minutes()
+	} else {
+		inside = current >= start.minutes() || current < end.minutes()
+	}
+	if inside {
+		return now, nil
+	}
+
+	next := start.on(local.Year(), local.Month(), local.Day(), location)
+	if !next.After(local) {
+		next = start.on(local.Year(), local.Month(), local.Day()+1, location)
+	}
+	return next, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"SharepointBot/config"
+	"testing"
+	"time"
+)
+
+// TestNextWindowStart preveri okna čez polnoč in ob prehodih na poletni in zimski čas.
+func TestNextWindowStart(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Ljubljana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(value string) time.Time {
+		result, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	night := &config.Window{Start: "22:00", End: "06:00"}
+	day := &config.Window{Start: "08:00", End: "16:00"}
+
+	tests := []struct {
+		name   string
+		window *config.Window
+		now    time.Time
+		want   time.Time
+	}{
+		{"no window", nil, at("2026-05-04 03:00"), at("2026-05-04 03:00")},
+		{"always open", &config.Window{Start: "07:00", End: "07:00"}, at("2026-05-04 03:00"), at("2026-05-04 03:00")},
+		{"inside", day, at("2026-05-04 12:00"), at("2026-05-04 12:00")},
+		{"before start", day, at("2026-05-04 07:59"), at("2026-05-04 08:00")},
+		{"at end", day, at("2026-05-04 16:00"), at("2026-05-05 08:00")},
+		{"after end", day, at("2026-05-04 20:00"), at("2026-05-05 08:00")},
+		{"end of month", day, at("2026-05-31 20:00"), at("2026-06-01 08:00")},
+		{"midnight before", night, at("2026-05-04 23:30"), at("2026-05-04 23:30")},
+		{"midnight after", night, at("2026-05-05 05:59"), at("2026-05-05 05:59")},
+		{"midnight closed", night, at("2026-05-05 06:00"), at("2026-05-05 22:00")},
+		// 29. 3. 2026 ura skoči z 02:00 na 03:00, dan ima 23 ur
+		{"spring forward inside", night, at("2026-03-29 03:30"), at("2026-03-29 03:30")},
+		{"spring forward closed", night, at("2026-03-29 07:00"), at("2026-03-29 22:00")},
+		{"spring forward next day", day, at("2026-03-28 20:00"), at("2026-03-29 08:00")},
+		// 25. 10. 2026 se ura z 03:00 vrne na 02:00, dan ima 25 ur
+		{"fall back closed", night, at("2026-10-25 12:00"), at("2026-10-25 22:00")},
+		{"fall back next day", day, at("2026-10-24 20:00"), at("2026-10-25 08:00")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NextWindowStart(test.window, location, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("NextWindowStart(%v) = %v, want %v", test.now, got, test.want)
+			}
+		})
+	}
+
+	_, err = NextWindowStart(&config.Window{Start: "25:00", End: "06:00"}, location, at("2026-05-04 12:00"))
+	if err == nil {
+		t.Error("expected an error for an invalid window")
+	}
+}